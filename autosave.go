@@ -0,0 +1,145 @@
+// autosave.go - database in go
+/*
+ * This file autosave.go is part of L1VMgodata.
+ *
+ * (c) Copyright Stefan Pietzonke (jay-t@gmx.net), 2025
+ *
+ * L1VMgodata is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * L1VMgodata is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with L1VMgodata.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+// automatic snapshots of the data into the database root:
+// every ":autosave-interval" seconds, if there are at least ":autosave-changes" data changes.
+// Every database gets its own snapshot: "autosave-<database>-<date>-<time>.l1db".
+// Only the last ":autosave-keep" snapshots of a database are kept.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	AUTOSAVE_PREFIX = "autosave-"
+	AUTOSAVE_SUFFIX = ".l1db"
+)
+
+var autosave_interval uint64 = 0 // seconds, 0 = autosave off
+var autosave_changes uint64 = 1  // min number of data changes for a snapshot
+var autosave_keep uint64 = 10    // number of snapshots to keep, 0 = keep all
+
+var data_changes uint64 = 0  // data changes since the last snapshot
+var last_save_time int64 = 0 // unix time of the last successful save
+var amutex sync.Mutex        // autosave mutex
+
+// count a data change for the autosave
+func count_data_change() {
+	amutex.Lock()
+	data_changes++
+	amutex.Unlock()
+}
+
+// set the time of the last successful save
+func set_last_save() {
+	amutex.Lock()
+	last_save_time = time.Now().Unix()
+	amutex.Unlock()
+}
+
+// get unix time of the last successful save, 0 if there was none
+func get_last_save() int64 {
+	amutex.Lock()
+	last := last_save_time
+	amutex.Unlock()
+	return last
+}
+
+func autosave_loop() {
+	var changes uint64
+
+	print_message("autosave: every " + strconv.FormatUint(autosave_interval, 10) + " seconds")
+	for {
+		time.Sleep(time.Duration(autosave_interval) * time.Second)
+
+		amutex.Lock()
+		changes = data_changes
+		amutex.Unlock()
+
+		if changes < autosave_changes || changes == 0 {
+			continue
+		}
+		autosave()
+	}
+}
+
+// write a new snapshot of every database and remove the old ones
+// returns 1 on error
+func autosave() int {
+	var changes uint64
+	var err int = 0
+
+	amutex.Lock()
+	changes = data_changes
+	amutex.Unlock()
+
+	save_time := time.Now().Format("20060102-150405")
+	for _, db := range get_databases() {
+		file_name := AUTOSAVE_PREFIX + db.name + "-" + save_time + AUTOSAVE_SUFFIX
+		if save_data(db, database_root+file_name) != 0 {
+			print_message("autosave: Error saving " + file_name)
+			err = 1
+			continue
+		}
+		print_message("autosave: " + file_name + " saved")
+		remove_old_autosaves(db)
+	}
+	if err != 0 {
+		return 1
+	}
+
+	// changes made while saving are counted for the next snapshot
+	amutex.Lock()
+	data_changes = data_changes - changes
+	amutex.Unlock()
+	return 0
+}
+
+func remove_old_autosaves(db *database) {
+	var i int
+
+	if autosave_keep == 0 {
+		return
+	}
+
+	files, err := filepath.Glob(filepath.Join(database_root, AUTOSAVE_PREFIX+db.name+"-*"+AUTOSAVE_SUFFIX))
+	if err != nil {
+		print_message("autosave: Error getting snapshots: " + err.Error())
+		return
+	}
+
+	// the time in the file name sorts the oldest first
+	sort.Strings(files)
+	for i = 0; i < len(files)-int(autosave_keep); i++ {
+		err = os.Remove(files[i])
+		if err != nil {
+			print_message("autosave: Error removing old snapshot: " + err.Error())
+		} else {
+			print_message("autosave: removed old snapshot " + filepath.Base(files[i]))
+		}
+	}
+}
@@ -32,25 +32,23 @@ const (
 )
 
 func set_blacklist_ip(ip string) {
-	dmutex.Lock()
+	bmutex.Lock()
 	blacklist_ip = append(blacklist_ip, ip)
 	blacklist_ip_ind++
-	dmutex.Unlock()
+	bmutex.Unlock()
 }
 
 func check_blacklist(ip string) bool {
 	var i uint64
 
-	if blacklist_ip_ind > 0 {
-		dmutex.Lock()
-		for i = 0; i < blacklist_ip_ind; i++ {
-			if blacklist_ip[i] == ip {
-				dmutex.Unlock()
-				return true
-			}
+	bmutex.RLock()
+	for i = 0; i < blacklist_ip_ind; i++ {
+		if blacklist_ip[i] == ip {
+			bmutex.RUnlock()
+			return true
 		}
-		dmutex.Unlock()
 	}
+	bmutex.RUnlock()
 
 	return false
 }
@@ -67,7 +65,7 @@ func read_ip_blacklist() bool {
 
 	// read one IP per line
 	scanner := bufio.NewScanner(file)
-	dmutex.Lock()
+	bmutex.Lock()
 	for scanner.Scan() {
 		line := scanner.Text()
 		// store ip
@@ -77,7 +75,7 @@ func read_ip_blacklist() bool {
 			blacklist_ip_ind++
 		}
 	}
-	dmutex.Unlock()
+	bmutex.Unlock()
 	return true
 }
 
@@ -92,15 +90,15 @@ func write_ip_blacklist() bool {
 	// remember to close the file
 	defer file.Close()
 
-	dmutex.Lock()
+	bmutex.Lock()
 	for i = 0; i < blacklist_ip_ind; i++ {
 		_, err = file.WriteString(blacklist_ip[i] + "\n")
 		if err != nil {
 			fmt.Println("Error writing blacklist file:", err.Error())
-			dmutex.Unlock()
+			bmutex.Unlock()
 			return false
 		}
 	}
-	dmutex.Unlock()
+	bmutex.Unlock()
 	return true
 }
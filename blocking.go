@@ -0,0 +1,176 @@
+// blocking.go - database in go
+/*
+ * This file blocking.go is part of L1VMgodata.
+ *
+ * (c) Copyright Stefan Pietzonke (jay-t@gmx.net), 2025
+ *
+ * L1VMgodata is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * L1VMgodata is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with L1VMgodata.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+// blocking pops: "blpop" and "brpop" wait for an element, if the list is empty.
+// The waiting clients of a key are in a queue, the first one gets the next pushed element.
+// A push gives the element to the waiting client under the data lock, so no other client can pop it before.
+// A waiting client doesn't hold the data lock, it waits for its channel, the timeout or the shutdown.
+// The connection of a waiting client is watched, a client which closed it gets no element.
+
+package main
+
+import (
+	"bufio"
+	"net"
+	"time"
+)
+
+// a client waiting for an element of a list
+type list_waiter struct {
+	left   bool          // pop at the head
+	result chan list_pop // gets the element, buffered so a push never waits
+	closed chan bool     // closed if the client closed the connection
+}
+
+// the result of a blocking pop
+type list_pop struct {
+	err   int
+	value string
+}
+
+// remove the element at the head (left) or the tail of a list, wait for it if the list is empty.
+// seconds is the timeout, 0 = wait until an element is pushed. closed is closed if the client closed the connection.
+// returns LIST_TIMEOUT on timeout, shutdown or a closed connection
+func pop_list_wait(db *database, key string, left bool, seconds uint64, closed chan bool) (int, string) {
+	var timeout <-chan time.Time = nil
+
+	db.mutex.Lock()
+	err, value := pop_list_locked(db, key, left)
+	if err != LIST_ERROR || is_dropped(db) {
+		// got the element, the key is not a list or the database was dropped
+		db.mutex.Unlock()
+		return err, value
+	}
+	waiter := &list_waiter{left: left, result: make(chan list_pop, 1), closed: closed}
+	db.waiters[key] = append(db.waiters[key], waiter)
+	db.mutex.Unlock()
+
+	if seconds > 0 {
+		timer := time.NewTimer(time.Duration(seconds) * time.Second)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case pop := <-waiter.result:
+		return pop.err, pop.value
+	case <-timeout:
+	case <-shutdown_chan:
+	case <-closed:
+	}
+
+	db.mutex.Lock()
+	remove_list_waiter(db, key, waiter)
+	db.mutex.Unlock()
+
+	// an element can be given to the client before it was removed from the queue
+	select {
+	case pop := <-waiter.result:
+		return pop.err, pop.value
+	default:
+	}
+	return LIST_TIMEOUT, ""
+}
+
+// give the elements of a list to the waiting clients, first come first served.
+// In a transaction this is done at the commit. db.mutex must be locked
+func serve_list_waiters(db *database, key string) {
+	if len(db.waiters[key]) == 0 {
+		return
+	}
+	if db.journal != nil {
+		db.journal.pushed = append(db.journal.pushed, key)
+		return
+	}
+
+	for len(db.waiters[key]) > 0 {
+		waiter := db.waiters[key][0]
+		select {
+		case <-waiter.closed:
+			// the client closed the connection, the element is given to the next one
+			remove_list_waiter(db, key, waiter)
+			continue
+		default:
+		}
+		err, value := pop_list_locked(db, key, waiter.left)
+		if err != LIST_OK {
+			// the list is empty
+			return
+		}
+		remove_list_waiter(db, key, waiter)
+		waiter.result <- list_pop{err: LIST_OK, value: value}
+	}
+}
+
+// remove a client from the queue of a key, db.mutex must be locked
+func remove_list_waiter(db *database, key string, waiter *list_waiter) {
+	waiters := db.waiters[key]
+	for w := range waiters {
+		if waiters[w] == waiter {
+			waiters = remove_element_by_index(waiters, uint64(w))
+			break
+		}
+	}
+	if len(waiters) == 0 {
+		delete(db.waiters, key)
+	} else {
+		db.waiters[key] = waiters
+	}
+}
+
+// wake all waiting clients of a dropped database with an error, db.mutex must be locked
+func wake_list_waiters(db *database) {
+	for key, waiters := range db.waiters {
+		for _, waiter := range waiters {
+			waiter.result <- list_pop{err: LIST_ERROR}
+		}
+		delete(db.waiters, key)
+	}
+}
+
+// watch the connection of a waiting client, the returned channel is closed if the client closes it.
+// The watch reads nothing from the reader, a command sent while waiting is kept for the next read.
+// stop ends the watch, it must be called before the next read
+func watch_connection(connection net.Conn, reader *bufio.Reader) (chan bool, func()) {
+	closed := make(chan bool)
+	done := make(chan bool)
+
+	go func() {
+		_, err := reader.Peek(1)
+		if err != nil {
+			if net_err, ok := err.(net.Error); !ok || !net_err.Timeout() {
+				close(closed)
+			}
+		}
+		close(done)
+	}()
+
+	stop := func() {
+		// a waiting read returns at once
+		connection.SetReadDeadline(time.Now())
+		<-done
+		connection.SetReadDeadline(time.Time{})
+		if server_is_shutdown() {
+			// stop_clients set the deadline for the shutdown
+			connection.SetReadDeadline(time.Now())
+		}
+	}
+	return closed, stop
+}
@@ -0,0 +1,132 @@
+// counter.go - database in go
+/*
+ * This file counter.go is part of L1VMgodata.
+ *
+ * (c) Copyright Stefan Pietzonke (jay-t@gmx.net), 2025
+ *
+ * L1VMgodata is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * L1VMgodata is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with L1VMgodata.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+// counters: the number value of a key is changed under the data lock.
+// So clients can share sequence numbers without a race between "get key" and "store data".
+// A key which is not set counts from 0.
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// error codes of the counter functions
+const (
+	COUNTER_OK         = 0
+	COUNTER_NOT_NUMBER = 1 // the value of the key is not a number
+	COUNTER_ERROR      = 2 // overflow or no free space
+)
+
+// add n to the integer value of a key, returns the new value
+func incr_data(db *database, key string, n int64) (int, string) {
+	db.mutex.Lock()
+	err, value := change_counter(db, key, incr_change(key, n))
+	db.mutex.Unlock()
+	return err, value
+}
+
+// add f to the floating point value of a key, returns the new value
+func incr_data_float(db *database, key string, f float64) (int, string) {
+	db.mutex.Lock()
+	err, value := change_counter(db, key, incr_float_change(key, f))
+	db.mutex.Unlock()
+	return err, value
+}
+
+// the change of a counter by n
+func incr_change(key string, n int64) func(value string) (int, string) {
+	return func(value string) (int, string) {
+		number, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return COUNTER_NOT_NUMBER, ""
+		}
+		if (n > 0 && number > math.MaxInt64-n) || (n < 0 && number < math.MinInt64-n) {
+			fmt.Println("incr_data: error overflow of key: " + key)
+			return COUNTER_ERROR, ""
+		}
+		return COUNTER_OK, strconv.FormatInt(number+n, 10)
+	}
+}
+
+// the change of a floating point counter by f
+func incr_float_change(key string, f float64) func(value string) (int, string) {
+	return func(value string) (int, string) {
+		number, err := strconv.ParseFloat(value, 64)
+		if err != nil || math.IsNaN(number) || math.IsInf(number, 0) {
+			return COUNTER_NOT_NUMBER, ""
+		}
+		number = number + f
+		if math.IsInf(number, 0) {
+			fmt.Println("incr_data_float: error overflow of key: " + key)
+			return COUNTER_ERROR, ""
+		}
+		return COUNTER_OK, strconv.FormatFloat(number, 'f', -1, 64)
+	}
+}
+
+// set the value of a key to the result of change, the time to live and the type of the key are kept.
+// An int value becomes a float value, if the result is not a whole number. db.mutex must be locked
+func change_counter(db *database, key string, change func(value string) (int, string)) (int, string) {
+	var err int
+	var value string = "0"
+	var expire int64 = 0
+	var vtype string = TYPE_STRING
+
+	i, ok := get_key_index(db, key)
+	if ok {
+		value = strings.Trim(db.data[i].value, "'\n")
+		expire = db.data[i].expire
+		vtype = db.data[i].vtype
+	}
+	if vtype == TYPE_BOOL || vtype == TYPE_BYTES || vtype == TYPE_LIST {
+		return COUNTER_NOT_NUMBER, ""
+	}
+
+	err, value = change(value)
+	if err != COUNTER_OK {
+		return err, ""
+	}
+	if vtype == TYPE_INT {
+		_, perr := strconv.ParseInt(value, 10, 64)
+		if perr != nil {
+			vtype = TYPE_FLOAT
+		}
+	}
+
+	err, i = set_data(db, key, value)
+	if err != 0 {
+		fmt.Println("error: can't get free space for data!")
+		return COUNTER_ERROR, ""
+	}
+	log_write(db, LOG_STORE, key, value, strconv.FormatInt(db.data[i].updated, 10))
+	if vtype != TYPE_STRING {
+		set_type(db, i, vtype)
+		log_write(db, LOG_TYPE, key, vtype)
+	}
+	if expire != 0 {
+		set_expire(db, i, expire)
+		log_write(db, LOG_EXPIRE, key, strconv.FormatInt(expire, 10))
+	}
+	return COUNTER_OK, value
+}
@@ -0,0 +1,194 @@
+// database.go - database in go
+/*
+ * This file database.go is part of L1VMgodata.
+ *
+ * (c) Copyright Stefan Pietzonke (jay-t@gmx.net), 2025
+ *
+ * L1VMgodata is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * L1VMgodata is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with L1VMgodata.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+// named databases: every database has its own keys, links, lock and save file.
+// A client uses the "default" database until it sends "use db :name".
+// The settings from "settings.l1db" are in their own database, which clients can't use.
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	DEFAULT_DATABASE = "default"
+	DATABASE_SUFFIX  = ".l1db"
+)
+
+type database struct {
+	mutex         sync.RWMutex // RLock for reading data, Lock for changing data
+	name          string
+	data          []data
+	key_index     map[string]uint64         // data index of every used key
+	free_slots    []uint64                  // indexes of removed data entries, used again first
+	expiring      map[string]bool           // keys with an expire time
+	reserving     map[string]bool           // lists with reserved messages
+	queues        map[string]*queue_counter // counters of the lists used as queues, kept if a list is removed
+	memory        uint64                    // memory of the data in bytes
+	access        []int64                   // last access time of every data entry, for the eviction
+	hits          []uint64                  // number of accesses of every data entry, for the eviction
+	history_depth int                       // number of old values kept of every key, 0 = no history
+	waiters       map[string][]*list_waiter // clients waiting in "blpop" or "brpop" for a list
+	change_number uint64                    // number of the last data change, db.mutex must be locked
+	journal       *transaction_journal      // set while a transaction runs, db.mutex must be locked
+}
+
+// all databases by name. The map is never changed, "create db" and "drop db" store a new one.
+// So the databases are got without a lock, databases_mutex is locked to store a new map
+var databases atomic.Value
+var databases_mutex sync.Mutex
+var settings_db *database // the loaded config file, not in databases
+
+func new_database(name string) *database {
+	db := &database{name: name, waiters: make(map[string][]*list_waiter)}
+	clear_data(db)
+	return db
+}
+
+// check the name of a database, it is used in file names.
+// Only letters, digits and '_' are allowed: returns true if the name is illegal
+func check_database_name(name string) bool {
+	var i int
+
+	if name == "" {
+		fmt.Println("Error database name is empty!")
+		return true
+	}
+	for i = 0; i < len(name); i++ {
+		c := name[i]
+		if !((c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || c == '_') {
+			fmt.Println("Error database name: " + name + " is illegal!")
+			return true
+		}
+	}
+	return false
+}
+
+// get the map of all databases, it must not be changed
+func get_database_map() map[string]*database {
+	dbs, _ := databases.Load().(map[string]*database)
+	return dbs
+}
+
+// store a copy of the databases map with the database db set or removed, databases_mutex must be locked
+func set_database_map(name string, db *database) {
+	dbs := make(map[string]*database)
+	for n, d := range get_database_map() {
+		dbs[n] = d
+	}
+	if db == nil {
+		delete(dbs, name)
+	} else {
+		dbs[name] = db
+	}
+	databases.Store(dbs)
+}
+
+// remove all databases and create an empty default database
+func init_databases() {
+	databases_mutex.Lock()
+	databases.Store(map[string]*database{DEFAULT_DATABASE: new_database(DEFAULT_DATABASE)})
+	databases_mutex.Unlock()
+}
+
+// get a database by name, returns nil if it doesn't exist
+func get_database(name string) *database {
+	return get_database_map()[name]
+}
+
+// returns 1 if the name is illegal or the database already exists
+func create_database(name string) int {
+	if check_database_name(name) {
+		return 1
+	}
+
+	databases_mutex.Lock()
+	if get_database(name) != nil {
+		databases_mutex.Unlock()
+		return 1
+	}
+	set_database_map(name, new_database(name))
+	log_write(nil, LOG_CREATE, name)
+	databases_mutex.Unlock()
+	return 0
+}
+
+// remove a database and all of its data, the save file is kept.
+// returns 1 if it doesn't exist or is the default database
+func drop_database(name string) int {
+	if name == DEFAULT_DATABASE {
+		return 1
+	}
+
+	databases_mutex.Lock()
+	db := get_database(name)
+	if db == nil {
+		databases_mutex.Unlock()
+		return 1
+	}
+	// the clients using the database see that it is dropped when they lock it
+	db.mutex.Lock()
+	set_database_map(name, nil)
+	wake_list_waiters(db)
+	log_write(nil, LOG_DROP, name)
+	db.mutex.Unlock()
+	databases_mutex.Unlock()
+	return 0
+}
+
+// check if a database was dropped, or is the settings database which is not in the databases
+func is_dropped(db *database) bool {
+	return get_database(db.name) != db
+}
+
+// get all databases sorted by name
+func get_databases() []*database {
+	dbs := make([]*database, 0, len(get_database_map()))
+	for _, db := range get_database_map() {
+		dbs = append(dbs, db)
+	}
+	sort.Slice(dbs, func(i, j int) bool {
+		return dbs[i].name < dbs[j].name
+	})
+	return dbs
+}
+
+// get the names of all databases and the number of their keys, sorted by name
+func get_database_list() ([]string, []uint64) {
+	var names []string
+	var used []uint64
+
+	for _, db := range get_databases() {
+		db.mutex.RLock()
+		names = append(names, db.name)
+		used = append(used, uint64(len(db.key_index)))
+		db.mutex.RUnlock()
+	}
+	return names, used
+}
+
+// the save file of a database in the database root
+func get_database_file(db *database) string {
+	return database_root + db.name + DATABASE_SUFFIX
+}
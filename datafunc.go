@@ -31,386 +31,608 @@ import (
 	"unsafe"
 )
 
-// search if key was already set and return 1, or 0 if not already set!
-func search_key(search_key string) (int, uint64) {
-	var i uint64
+// conditions and return codes of store_data_if
+const (
+	STORE_IF_ABSENT  = 0
+	STORE_IF_PRESENT = 1
+	STORE_IF_VALUE   = 2
+	STORE_IF_VERSION = 3
+
+	STORE_OK     = 0
+	STORE_ERROR  = 1
+	STORE_FAILED = 2
+)
 
-	dmutex.Lock()
-	for i = 0; i < maxdata; i++ {
-		if (*pdata)[i].used {
-			if (*pdata)[i].key == search_key {
-				dmutex.Unlock()
-				// key already set
-				return 1, i
-			}
-		}
+// search if key was already set and return 1, or 0 if not already set!
+func search_key(db *database, search_key string) (int, uint64) {
+	db.mutex.RLock()
+	i, ok := get_key_index(db, search_key)
+	db.mutex.RUnlock()
+	if ok {
+		// key already set
+		return 1, i
 	}
-	dmutex.Unlock()
 	// key not found
-	return 0, i
+	return 0, 0
 }
 
-func init_data() {
-	var i uint64
-	var l uint64
-	var linkslen uint64
+func init_data(db *database) {
+	db.mutex.Lock()
+	clear_data(db)
+	db.mutex.Unlock()
+}
 
-	dmutex.Lock()
-	for i = 0; i < maxdata; i++ {
-		(*pdata)[i].used = false
-		(*pdata)[i].key = ""
-		(*pdata)[i].value = ""
-
-		linkslen = uint64(len((*pdata)[i].links))
-		if linkslen > 0 {
-			// there are links set, remove all:
-			for l = 0; l < linkslen; l++ {
-				(*pdata)[i].links = remove_element_by_index((*pdata)[i].links, l)
-			}
-		}
-	}
-	dmutex.Unlock()
-	data_index = 0
-	free_index = 0
+// erase all data of a database, for the "erase all" command
+func erase_data(db *database) {
+	db.mutex.Lock()
+	clear_data(db)
+	log_write(db, LOG_ERASE)
+	db.mutex.Unlock()
+}
+
+// db.mutex must be locked, if the database is used already
+func clear_data(db *database) {
+	newdata := make([]data, 0, DATA_START_SIZE)
+	db.data = newdata
+	db.key_index = make(map[string]uint64)
+	db.free_slots = nil
+	db.expiring = make(map[string]bool)
+	db.reserving = make(map[string]bool)
+	db.queues = make(map[string]*queue_counter)
+	set_memory(db, 0)
+	db.access = make([]int64, 0, DATA_START_SIZE)
+	db.hits = make([]uint64, 0, DATA_START_SIZE)
 }
 
-func get_free_space() (int, uint64) {
+// get index of a free data entry, db.mutex must be locked.
+// Removed entries are used again first, else the data grows by one entry.
+// returns 1 if maxdata keys are stored or there is not enough memory
+func get_free_index(db *database) (int, uint64) {
 	var i uint64
-	dmutex.Lock()
-	// check if free_index is free
-	if free_index < maxdata-1 {
-		if !(*pdata)[free_index].used {
-			// free_index is free, increase it by 1 to point to next place
-			free_index = free_index + 1
-			dmutex.Unlock()
-			return 0, free_index - 1
-		}
+
+	if maxdata > 0 && uint64(len(db.key_index)) >= maxdata {
+		fmt.Println("error: get_free_index: max data entries used:", maxdata)
+		return 1, 0
 	}
 
-	// search for free place
-	for i = 0; i < maxdata; i++ {
-		if !(*pdata)[i].used {
-			if free_index < maxdata-1 {
-				free_index = i + 1
-			}
-			dmutex.Unlock()
-			return 0, i
+	if len(db.free_slots) > 0 {
+		i = db.free_slots[len(db.free_slots)-1]
+		db.free_slots = db.free_slots[:len(db.free_slots)-1]
+		return 0, i
+	}
+
+	if len(db.data) == cap(db.data) {
+		// append has to allocate a bigger slice
+		if check_free_memory(uint64(cap(db.data))) == 1 {
+			return 1, 0
 		}
 	}
-	dmutex.Unlock()
-	// no free space found, return error code 1
-	return 1, i
+	db.data = append(db.data, data{})
+	db.access = append(db.access, 0)
+	db.hits = append(db.hits, 0)
+	return 0, uint64(len(db.data) - 1)
 }
 
-func try_to_allocate_more_space() int {
-	var new_datasize uint64
-	var free_system_ram uint64
-	var max_alloc_size uint64
+// check if there is enough free system RAM for more data entries
+// returns 1 if not
+func check_free_memory(entries uint64) int {
 	var one_data data
-	var add_size uint64 = 20000
 
 	const one_data_size = uint64(unsafe.Sizeof(one_data))
 
-	// get the free system RAM
-	free_system_ram = memory.FreeMemory()
-	max_alloc_size = free_system_ram - (one_data_size * add_size)
-	if max_alloc_size < (one_data_size * add_size) {
-		// error out of memory!
-		fmt.Println("error: try_to_allocate_more_space: out of memory! dumping 'temp-data.db'")
-
-		// save the data into the temp data file
-		if save_data("temp-data.db") == 1 {
-			fmt.Println("error: try_to_allocate_more_space: can't save 'temp-data.db' file!")
-			return 1
-		}
-
-		return 1
+	free_system_ram := memory.FreeMemory()
+	if free_system_ram == 0 {
+		// free RAM is unknown on this system
+		return 0
 	}
-
-	// save the data into the temp data file
-	if save_data("temp-data.db") == 1 {
-		fmt.Println("error: try_to_allocate_more_space: can't save 'temp-data.db' file!")
+	if free_system_ram < one_data_size*entries {
+		fmt.Println("error: check_free_memory: out of memory!")
 		return 1
 	}
+	return 0
+}
 
-	// clear pdata
-	pdata = nil
-
-	// allocate bigger data slice
-	new_datasize = maxdata + add_size
-	newdata := make([]data, new_datasize) // make serverdata slice
-	pdata = &newdata
-	maxdata = new_datasize
-
-	fmt.Println("new datasize:", maxdata)
-
-	init_data()
-
-	// load temporary saved database
-	data_index = 0
-
-	if load_data("temp-data.db") == 1 {
-		fmt.Println("error: try_to_allocate_more_space: can't load 'temp-data.db' file into new data slice!")
-		return 1
+// set up a free data entry for a new key, without a value. db.mutex must be locked
+// returns 1 if there is no free space
+func new_data(db *database, key string) (int, uint64) {
+	err, i := get_free_index(db)
+	if err == 1 {
+		return 1, i
 	}
-
-	return 0
+	change_entry(db, i)
+	db.data[i].used = true
+	db.data[i].key = key
+	db.data[i].value = ""
+	db.data[i].links = nil
+	db.data[i].linked_by = nil
+	db.data[i].history = nil
+	db.data[i].list = nil
+	db.data[i].reserved = nil
+	db.data[i].created = get_time_ms()
+	db.data[i].version = 0
+	db.key_index[key] = i
+	db.access[i] = 0
+	db.hits[i] = 0
+	return 0, i
 }
 
-func store_data(key string, value string) uint64 {
-	var i uint64 = 0
+// set the value of a key, a new key gets a free data entry. db.mutex must be locked
+// returns 1 if there is no free space
+func set_data(db *database, key string, value string) (int, uint64) {
 	var err int = 0
+	var size uint64 = 0
+	var before uint64 = 0
+
+	// an expired key is stored as a new key
+	expire_key(db, key)
+
+	// memory needed for the new value
+	i, ok := db.key_index[key]
+	if !ok {
+		size = DATA_ENTRY_MEMORY + 2*uint64(len(key)) + uint64(len(value))
+	} else if db.history_depth > 0 {
+		// the old value is kept in the history
+		size = HISTORY_MEMORY + uint64(len(value))
+	} else if len(value) > len(db.data[i].value) {
+		size = uint64(len(value) - len(db.data[i].value))
+	}
+	if size > 0 && evict_data(db, key, size) != 0 {
+		return 1, 0
+	}
 
-	err, i = search_key(key)
-	if err == 0 {
-		// key not already used, get free space
-		err, i = get_free_space()
+	if !ok {
+		err, i = new_data(db, key)
 		if err == 1 {
-			// error: no free space
-			// try to allocate bigger array
-			err = try_to_allocate_more_space()
-			if err == 1 {
-				fmt.Println("error: can't allocate more space for data!")
-				return 1
-			}
-			err, i = get_free_space()
-			if err == 1 {
-				fmt.Println("error: can't get free space for data!")
-				return 1
-			}
+			return 1, i
 		}
+	} else {
+		change_entry(db, i)
+		before = entry_memory(&db.data[i])
+		push_history(db, i)
 	}
+	db.data[i].value = value
+	db.data[i].vtype = TYPE_STRING
+	db.data[i].list = nil
+	db.data[i].reserved = nil
+	delete(db.reserving, key)
+	db.data[i].updated = get_time_ms()
+	db.data[i].version++
+	update_memory(db, i, before)
+	touch_data(db, i)
+	// a stored key doesn't expire, until the expire time is set again
+	set_expire(db, i, 0)
+	return 0, i
+}
 
-	// store data at index i
-	dmutex.Lock()
-	(*pdata)[i].used = true
-	(*pdata)[i].key = key
-	(*pdata)[i].value = value
-	dmutex.Unlock()
-	return 0
+func store_data(db *database, key string, value string) uint64 {
+	db.mutex.Lock()
+	err := store_data_locked(db, key, value)
+	db.mutex.Unlock()
+	return err
 }
 
-func store_data_new(key string, value string) uint64 {
-	// don't check if key is already in database
-	var i uint64 = 0
+// db.mutex must be locked
+func store_data_locked(db *database, key string, value string) uint64 {
 	var err int = 0
+	var i uint64
 
-	// get free space
-	err, i = get_free_space()
+	err, i = set_data(db, key, value)
 	if err == 1 {
-		// error: no free space
-		// try to allocate bigger array
-		err = try_to_allocate_more_space()
-		if err == 1 {
-			fmt.Println("error: can't allocate more space for data!")
-			return 1
-		}
-		err, i = get_free_space()
-		if err == 1 {
-			fmt.Println("error: can't get free space for data!")
-			return 1
+		fmt.Println("error: can't get free space for data!")
+		return 1
+	}
+	log_write(db, LOG_STORE, key, value, strconv.FormatInt(db.data[i].updated, 10))
+	return 0
+}
+
+// store a key only if the condition is true, checked under the same lock as the store:
+// STORE_IF_ABSENT: the key is not set, STORE_IF_PRESENT: the key is set,
+// STORE_IF_VALUE: the key is set to the expected value (compare and swap),
+// STORE_IF_VERSION: the key has the expected version, version "0" if the key is not set.
+// returns STORE_OK, STORE_FAILED if the condition is false or STORE_ERROR
+func store_data_if(db *database, key string, value string, condition int, expected string) int {
+	db.mutex.Lock()
+	err := store_data_if_locked(db, key, value, condition, expected)
+	db.mutex.Unlock()
+	return err
+}
+
+// db.mutex must be locked
+func store_data_if_locked(db *database, key string, value string, condition int, expected string) int {
+	i, ok := get_key_index(db, key)
+	switch condition {
+	case STORE_IF_ABSENT:
+		ok = !ok
+	case STORE_IF_VALUE:
+		ok = ok && strings.Trim(db.data[i].value, "'\n") == expected
+	case STORE_IF_VERSION:
+		if ok {
+			ok = strconv.FormatUint(db.data[i].version, 10) == expected
+		} else {
+			ok = expected == "0"
 		}
 	}
+	if !ok {
+		return STORE_FAILED
+	}
+	if store_data_locked(db, key, value) != 0 {
+		return STORE_ERROR
+	}
+	return STORE_OK
+}
 
-	// store data at index i
-	dmutex.Lock()
-	(*pdata)[i].used = true
-	(*pdata)[i].key = key
-	(*pdata)[i].value = value
-	dmutex.Unlock()
-	return 0
+func store_data_new(db *database, key string, value string) uint64 {
+	// the key index makes the check if a key is already used as fast as storing new data.
+	// So this is the same as store_data now, and a key can't be stored twice anymore
+	return store_data(db, key, value)
 }
 
-func get_data_key_regexp(key string) string {
+func get_data_key_regexp(db *database, key string) string {
 	var i uint64
 	var match bool
 
 	skey := strings.Trim(key, "\n")
-	dmutex.Lock()
-	for i = 0; i < maxdata; i++ {
-		if (*pdata)[i].used {
-			match, _ = regexp.MatchString(skey, (*pdata)[i].key)
+	db.mutex.RLock()
+	now := get_time_ms()
+	for i = 0; i < uint64(len(db.data)); i++ {
+		if is_used(&db.data[i], now) {
+			match, _ = regexp.MatchString(skey, db.data[i].key)
 			if match {
-				dmutex.Unlock()
-				nvalue := strings.Trim((*pdata)[i].key, "'\n")
+				nvalue := strings.Trim(db.data[i].key, "'\n")
+				db.mutex.RUnlock()
 				return nvalue
 			}
 		}
 	}
-	dmutex.Unlock()
+	db.mutex.RUnlock()
 	// no matching key found, return empty string
 	return ""
 }
 
-func get_data_value_regexp(value string) string {
+func get_data_value_regexp(db *database, value string) string {
 	var i uint64
 	var match bool
 
 	svalue := strings.Trim(value, "\n")
-	dmutex.Lock()
-	for i = 0; i < maxdata; i++ {
-		if (*pdata)[i].used {
-			match, _ = regexp.MatchString(svalue, (*pdata)[i].value)
+	db.mutex.RLock()
+	now := get_time_ms()
+	for i = 0; i < uint64(len(db.data)); i++ {
+		if is_used(&db.data[i], now) {
+			match, _ = regexp.MatchString(svalue, db.data[i].value)
 			if match {
-				dmutex.Unlock()
-				nvalue := strings.Trim((*pdata)[i].key, "'\n")
+				nvalue := strings.Trim(db.data[i].key, "'\n")
+				db.mutex.RUnlock()
 				return nvalue
 			}
 		}
 	}
-	dmutex.Unlock()
+	db.mutex.RUnlock()
 	// no matching value found, return empty string
 	return ""
 }
 
-func get_data_key(key string) string {
+func get_data_key(db *database, key string) string {
+	// exact match of the key, use get_data_key_contains or get_data_key_prefix for a search
+	db.mutex.RLock()
+	nvalue := get_data_key_locked(db, key)
+	db.mutex.RUnlock()
+	return nvalue
+}
+
+// db.mutex must be locked
+func get_data_key_locked(db *database, key string) string {
+	skey := strings.Trim(key, "\n")
+	i, ok := get_key_index(db, skey)
+	if ok {
+		return strings.Trim(db.data[i].value, "'\n")
+	}
+	// no matching key found, return empty string
+	return ""
+}
+
+func get_data_key_contains(db *database, key string) string {
 	var i uint64
 	var match bool
 
 	skey := strings.Trim(key, "\n")
-	dmutex.Lock()
-	for i = 0; i < maxdata; i++ {
-		if (*pdata)[i].used {
-			match = strings.Contains((*pdata)[i].key, skey)
+	db.mutex.RLock()
+	now := get_time_ms()
+	for i = 0; i < uint64(len(db.data)); i++ {
+		if is_used(&db.data[i], now) {
+			match = strings.Contains(db.data[i].key, skey)
 			if match {
-				dmutex.Unlock()
-				nvalue := strings.Trim((*pdata)[i].value, "'\n")
+				nvalue := strings.Trim(db.data[i].value, "'\n")
+				db.mutex.RUnlock()
 				return nvalue
 			}
 		}
 	}
-	dmutex.Unlock()
+	db.mutex.RUnlock()
 	// no matching key found, return empty string
 	return ""
 }
 
-func get_data_value(value string) string {
+func get_data_key_prefix(db *database, key string) string {
 	var i uint64
 	var match bool
 
-	svalue := strings.Trim(value, "\n")
-
-	dmutex.Lock()
-	for i = 0; i < maxdata; i++ {
-		if (*pdata)[i].used {
-			match = strings.Contains((*pdata)[i].value, svalue)
+	skey := strings.Trim(key, "\n")
+	db.mutex.RLock()
+	now := get_time_ms()
+	for i = 0; i < uint64(len(db.data)); i++ {
+		if is_used(&db.data[i], now) {
+			match = strings.HasPrefix(db.data[i].key, skey)
 			if match {
-				dmutex.Unlock()
-				nvalue := strings.Trim((*pdata)[i].key, "'\n")
+				nvalue := strings.Trim(db.data[i].value, "'\n")
+				db.mutex.RUnlock()
 				return nvalue
 			}
 		}
 	}
-	dmutex.Unlock()
-	// no matching value found, return empty string
+	db.mutex.RUnlock()
+	// no matching key found, return empty string
 	return ""
 }
 
-func remove_data(key string) string {
+func get_data_value(db *database, value string) string {
 	var i uint64
-	var j uint64
-	var l uint64
-	//var linkindex uint64
 	var match bool
-	var value string
-	var linkslen uint64 = 0
-	skey := strings.Trim(key, "\n")
-	// regexp := regexp.MustCompile(skey)
 
-	dmutex.Lock()
-	for i = 0; i < maxdata; i++ {
-		if (*pdata)[i].used {
-			// match = regexp.Match([]byte((*pdata)[i].key))
-			match = (*pdata)[i].key == skey
-			if match {
-				fmt.Println("remove data: found match...")
-				for j = 0; j < maxdata; j++ {
-					if (*pdata)[j].used {
-						linkslen = uint64(len((*pdata)[j].links))
-						if linkslen > 0 {
-							for l = 0; l < linkslen; l++ {
-								// try remove the data link, if possible
-								// linkindex = (*pdata)[j].links[l]
-								//if (*pdata)[linkindex].key == skey {
-								dmutex.Unlock()
-								fmt.Println("remove data: key: " + (*pdata)[j].key)
-								_ = remove_link((*pdata)[j].key, skey)
-								dmutex.Lock()
-								//}
-							}
-						}
-					}
-				}
-				value = (*pdata)[i].value
-				(*pdata)[i].used = false
-				(*pdata)[i].key = ""
-				(*pdata)[i].value = ""
+	svalue := strings.Trim(value, "\n")
 
-				nvalue := strings.Trim(value, "'\n")
-				dmutex.Unlock()
+	db.mutex.RLock()
+	now := get_time_ms()
+	for i = 0; i < uint64(len(db.data)); i++ {
+		if is_used(&db.data[i], now) {
+			match = strings.Contains(db.data[i].value, svalue)
+			if match {
+				nvalue := strings.Trim(db.data[i].key, "'\n")
+				db.mutex.RUnlock()
 				return nvalue
 			}
 		}
 	}
-	dmutex.Unlock()
-	// no matching key found, return empty string
+	db.mutex.RUnlock()
+	// no matching value found, return empty string
 	return ""
 }
 
-// get info about data base usage, return used space
-func get_used_elements() uint64 {
+// get all keys and values matching the search function, in data index order.
+// The first offset matches are skipped, limit 0 returns all matches
+func get_data_list(db *database, match_data func(d *data) bool, limit uint64, offset uint64) ([]string, []string) {
 	var i uint64
-	var free uint64 = 0
-
-	dmutex.Lock()
-	for i = 0; i < maxdata; i++ {
-		if (*pdata)[i].used {
-			free++
+	var found uint64 = 0
+	var keys []string
+	var values []string
+
+	db.mutex.RLock()
+	now := get_time_ms()
+	for i = 0; i < uint64(len(db.data)); i++ {
+		if is_used(&db.data[i], now) {
+			if match_data(&db.data[i]) {
+				found++
+				if found <= offset {
+					continue
+				}
+				keys = append(keys, db.data[i].key)
+				values = append(values, strings.Trim(db.data[i].value, "'\n"))
+				if limit > 0 && uint64(len(keys)) == limit {
+					break
+				}
+			}
 		}
 	}
-	dmutex.Unlock()
-	// return free data space
-	return free
+	db.mutex.RUnlock()
+	return keys, values
 }
 
-// link functions ==============================================================
-func get_data_key_compare(key string) (string, uint64) {
-	// don't use regex to compare, using normal string compare to find exact match
+func get_data_list_key_contains(db *database, key string, limit uint64, offset uint64) (int, []string, []string) {
+	keys, values := get_data_list(db, func(d *data) bool {
+		return strings.Contains(d.key, key)
+	}, limit, offset)
+	return 0, keys, values
+}
+
+func get_data_list_key_prefix(db *database, key string, limit uint64, offset uint64) (int, []string, []string) {
+	keys, values := get_data_list(db, func(d *data) bool {
+		return strings.HasPrefix(d.key, key)
+	}, limit, offset)
+	return 0, keys, values
+}
+
+func get_data_list_value(db *database, value string, limit uint64, offset uint64) (int, []string, []string) {
+	keys, values := get_data_list(db, func(d *data) bool {
+		return strings.Contains(d.value, value)
+	}, limit, offset)
+	return 0, keys, values
+}
+
+// returns 1 if the regex expression is not valid
+func get_data_list_key_regexp(db *database, key string, limit uint64, offset uint64) (int, []string, []string) {
+	regex, err := regexp.Compile(key)
+	if err != nil {
+		fmt.Println("get_data_list_key_regexp: error: " + err.Error())
+		return 1, nil, nil
+	}
+	keys, values := get_data_list(db, func(d *data) bool {
+		return regex.MatchString(d.key)
+	}, limit, offset)
+	return 0, keys, values
+}
+
+// returns 1 if the regex expression is not valid
+func get_data_list_value_regexp(db *database, value string, limit uint64, offset uint64) (int, []string, []string) {
+	regex, err := regexp.Compile(value)
+	if err != nil {
+		fmt.Println("get_data_list_value_regexp: error: " + err.Error())
+		return 1, nil, nil
+	}
+	keys, values := get_data_list(db, func(d *data) bool {
+		return regex.MatchString(d.value)
+	}, limit, offset)
+	return 0, keys, values
+}
+
+// get the keys of the next count used data entries, starting at data index cursor.
+// Only keys matching the regex pattern are returned, if it is set.
+// The data entries don't move while stored, so a key which is stored during the whole scan is found.
+// returns the cursor for the next call, 0 if the scan is complete
+func scan_data(db *database, cursor uint64, pattern string, count uint64) (int, uint64, []string) {
 	var i uint64
+	var examined uint64 = 0
+	var keys []string
+	var regex *regexp.Regexp
+	var err error
+
+	if pattern != "" {
+		regex, err = regexp.Compile(pattern)
+		if err != nil {
+			fmt.Println("scan_data: error: " + err.Error())
+			return 1, 0, nil
+		}
+	}
+
+	db.mutex.RLock()
+	now := get_time_ms()
+	for i = cursor; i < uint64(len(db.data)) && examined < count; i++ {
+		if is_used(&db.data[i], now) {
+			examined++
+			if regex == nil || regex.MatchString(db.data[i].key) {
+				keys = append(keys, db.data[i].key)
+			}
+		}
+	}
+	if i >= uint64(len(db.data)) {
+		// scan complete
+		i = 0
+	}
+	db.mutex.RUnlock()
+	return 0, i, keys
+}
+
+func remove_data(db *database, key string) string {
+	db.mutex.Lock()
+	nvalue := remove_data_locked(db, key)
+	db.mutex.Unlock()
+	return nvalue
+}
 
+// db.mutex must be locked
+func remove_data_locked(db *database, key string) string {
+	var value string
 	skey := strings.Trim(key, "\n")
 
-	dmutex.Lock()
-	for i = 0; i < maxdata; i++ {
-		if (*pdata)[i].used {
-			if skey == (*pdata)[i].key {
-				dmutex.Unlock()
-				nvalue := strings.Trim((*pdata)[i].value, "'\n")
-				return nvalue, i
-			}
+	expire_key(db, skey)
+	i, ok := db.key_index[skey]
+	if !ok {
+		// the counters of a queue without messages are removed too
+		if remove_queue(db, skey) {
+			log_write(db, LOG_REMOVE, skey)
+		}
+		// no matching key found, return empty string
+		return ""
+	}
+	value = db.data[i].value
+	delete_data(db, i)
+	remove_queue(db, skey)
+	log_write(db, LOG_REMOVE, skey)
+
+	return strings.Trim(value, "'\n")
+}
+
+// remove the data entry and all links to it, db.mutex must be locked
+func delete_data(db *database, i uint64) {
+	key := db.data[i].key
+
+	change_entry(db, i)
+	// remove the links to this key, the reverse links are the keys which link to it
+	for _, link_key := range db.data[i].linked_by {
+		k, ok := db.key_index[link_key]
+		if ok {
+			change_entry(db, k)
+			before := entry_memory(&db.data[k])
+			db.data[k].links, _ = remove_string(db.data[k].links, key)
+			update_memory(db, k, before)
+		}
+	}
+	// remove the reverse links of the keys this key links to
+	for _, link_key := range db.data[i].links {
+		k, ok := db.key_index[link_key]
+		if ok {
+			change_entry(db, k)
+			before := entry_memory(&db.data[k])
+			db.data[k].linked_by, _ = remove_string(db.data[k].linked_by, key)
+			update_memory(db, k, before)
 		}
 	}
-	dmutex.Unlock()
+
+	set_memory(db, db.memory-entry_memory(&db.data[i]))
+	set_expire(db, i, 0)
+	db.data[i].used = false
+	db.data[i].key = ""
+	db.data[i].value = ""
+	db.data[i].links = nil
+	db.data[i].linked_by = nil
+	db.data[i].history = nil
+	db.data[i].list = nil
+	db.data[i].reserved = nil
+	delete(db.key_index, key)
+	delete(db.reserving, key)
+	db.free_slots = append(db.free_slots, i)
+}
+
+// get info about data base usage, return used space and the data size:
+// maxdata if set, else 0: the data grows as needed, so there is no max number of keys
+func get_used_elements(db *database) (uint64, uint64) {
+	var used uint64
+
+	db.mutex.RLock()
+	used = uint64(len(db.key_index))
+	db.mutex.RUnlock()
+	return used, maxdata
+}
+
+// link functions ==============================================================
+func get_data_key_compare(db *database, key string) (string, uint64) {
+	// don't use regex to compare, using normal string compare to find exact match
+	skey := strings.Trim(key, "\n")
+
+	db.mutex.RLock()
+	i, ok := get_key_index(db, skey)
+	if ok {
+		nvalue := strings.Trim(db.data[i].value, "'\n")
+		db.mutex.RUnlock()
+		return nvalue, i
+	}
+	db.mutex.RUnlock()
 	// no matching key found, return empty string
-	return "", i
+	return "", 0
 }
 
-func set_link(key string, keylink string) int {
+func set_link(db *database, key string, keylink string) int {
+	db.mutex.Lock()
+	err := set_link_locked(db, key, keylink)
+	db.mutex.Unlock()
+	return err
+}
+
+// db.mutex must be locked
+func set_link_locked(db *database, key string, keylink string) int {
 	// set link between key and keylink data entries
 
-	var k uint64
 	var i uint64
-	var retstr string
 
-	retstr, k = get_data_key_compare(key)
-	if retstr == "" {
+	expire_key(db, key)
+	expire_key(db, keylink)
+	k, ok := db.key_index[key]
+	if !ok {
 		// key not found
 		// return error code
 		return 1
 	}
 
-	retstr, _ = get_data_key_compare(keylink)
-	if retstr == "" {
+	l, ok := db.key_index[keylink]
+	if !ok {
 		// key not found
 		// return error code
 		return 1
@@ -418,18 +640,23 @@ func set_link(key string, keylink string) int {
 
 	// both key and keylink are found
 	// check if link was already set
-	dmutex.Lock()
-	for i = 0; i < uint64(len((*pdata)[k].links)); i++ {
-		if (*pdata)[k].links[i] != "" {
+	for i = 0; i < uint64(len(db.data[k].links)); i++ {
+		if db.data[k].links[i] == keylink {
 			// error return, link was already set!
-			dmutex.Unlock()
 			return 1
 		}
 	}
 
-	// set the link
-	(*pdata)[k].links = append((*pdata)[k].links, keylink)
-	dmutex.Unlock()
+	// set the link, and the reverse link in the linked entry
+	change_entry(db, k)
+	before := entry_memory(&db.data[k])
+	db.data[k].links = append(db.data[k].links, keylink)
+	update_memory(db, k, before)
+	change_entry(db, l)
+	before = entry_memory(&db.data[l])
+	db.data[l].linked_by = append(db.data[l].linked_by, key)
+	update_memory(db, l, before)
+	log_write(db, LOG_LINK, key, keylink)
 
 	return 0
 }
@@ -438,93 +665,144 @@ func remove_element_by_index[T any](slice []T, index uint64) []T {
 	return append(slice[:index], slice[index+1:]...)
 }
 
-func remove_link(key string, keylink string) int {
-	// set link between key and keylink data entries
-
-	var k uint64
+// remove the first matching string, returns 1 if not found
+func remove_string(slice []string, str string) ([]string, int) {
 	var i uint64
-	var retstr string
 
-	retstr, k = get_data_key_compare(key)
-	if retstr == "" {
+	for i = 0; i < uint64(len(slice)); i++ {
+		if slice[i] == str {
+			return remove_element_by_index(slice, i), 0
+		}
+	}
+	return slice, 1
+}
+
+func remove_link(db *database, key string, keylink string) int {
+	db.mutex.Lock()
+	err := remove_link_locked(db, key, keylink)
+	db.mutex.Unlock()
+	return err
+}
+
+// db.mutex must be locked
+func remove_link_locked(db *database, key string, keylink string) int {
+	// remove link between key and keylink data entries
+
+	var err int
+
+	expire_key(db, key)
+	expire_key(db, keylink)
+	k, ok := db.key_index[key]
+	if !ok {
 		// key not found
 		// return error code
 		return 1
 	}
 
-	retstr, _ = get_data_key_compare(keylink)
-	if retstr == "" {
+	l, ok := db.key_index[keylink]
+	if !ok {
 		// keylink not found
 		// return error code
 		return 1
 	}
 
-	dmutex.Lock()
-
 	// both key and keylink are found
-	// search the keylink string index in links
-	for i = 0; i < uint64(len((*pdata)[k].links)); i++ {
-		if (*pdata)[k].links[i] == keylink {
-			// found matching index in the keys link list
-			// rermove it
-			(*pdata)[k].links = remove_element_by_index((*pdata)[k].links, i)
-			dmutex.Unlock()
-			return 0
-		}
+	// check if the link is set, then remove it
+	_, err = remove_string(append([]string(nil), db.data[k].links...), keylink)
+	if err == 1 {
+		// link not found
+		return 1
 	}
+	change_entry(db, k)
+	before := entry_memory(&db.data[k])
+	db.data[k].links, _ = remove_string(db.data[k].links, keylink)
+	update_memory(db, k, before)
+	change_entry(db, l)
+	before = entry_memory(&db.data[l])
+	db.data[l].linked_by, _ = remove_string(db.data[l].linked_by, key)
+	update_memory(db, l, before)
+	log_write(db, LOG_UNLINK, key, keylink)
+	return 0
+}
+
+// set the reverse links of all data entries from their links, db.mutex must be locked.
+// After loading a database file, because a link can be loaded before the linked key.
+// The memory of the database is counted again too.
+func set_reverse_links(db *database) {
+	var i uint64
 
-	// link not found
-	dmutex.Unlock()
-	return 1
+	for i = 0; i < uint64(len(db.data)); i++ {
+		db.data[i].linked_by = nil
+	}
+	for i = 0; i < uint64(len(db.data)); i++ {
+		if db.data[i].used {
+			for _, keylink := range db.data[i].links {
+				l, ok := db.key_index[keylink]
+				if ok {
+					db.data[l].linked_by = append(db.data[l].linked_by, db.data[i].key)
+				}
+			}
+		}
+	}
+	count_memory(db)
 }
 
-func get_number_of_links(key string) (uint64, string) {
+func get_number_of_links(db *database, key string) (uint64, string) {
 	var linkslen uint64
-	var k uint64
 	var retstr string
 
-	retstr, k = get_data_key_compare(key)
-	if retstr == "" {
+	skey := strings.Trim(key, "\n")
+
+	db.mutex.RLock()
+	k, ok := get_key_index(db, skey)
+	if !ok {
+		db.mutex.RUnlock()
 		// key not found
 		// return error code
 		return 1, ""
 	}
 
-	linkslen = uint64(len((*pdata)[k].links))
+	linkslen = uint64(len(db.data[k].links))
+	retstr = strings.Trim(db.data[k].value, "'\n")
+	db.mutex.RUnlock()
 
 	return linkslen, retstr
 }
 
-func get_link(key string, link_index uint64) string {
+func get_link(db *database, key string, link_index uint64) string {
 	var linkslen uint64
-	var k uint64
 	var retstr string
 
-	retstr, k = get_data_key_compare(key)
-	if retstr == "" {
+	skey := strings.Trim(key, "\n")
+
+	db.mutex.RLock()
+	k, ok := get_key_index(db, skey)
+	if !ok {
+		db.mutex.RUnlock()
 		// key not found
 		// return error code
 		return ""
 	}
 
-	linkslen = uint64(len((*pdata)[k].links))
-	if link_index < 0 || link_index >= linkslen {
+	linkslen = uint64(len(db.data[k].links))
+	if link_index >= linkslen {
+		db.mutex.RUnlock()
 		// error link index out of range
 		return ""
 	}
 
-	retstr = (*pdata)[k].links[link_index]
+	retstr = db.data[k].links[link_index]
+	db.mutex.RUnlock()
 
 	return retstr
 }
 
-func get_table_key(index uint64, key uint64) (string, string) {
-	var i uint64
+// get the key and value of a CSV table entry from the saved data
+func get_table_key(snapshot []data, index uint64, key uint64) (string, string) {
+	var i int
 	var search_index string = ""
-	var search_index_len uint64 = 0
+	var search_index_len int = 0
 	var keystr string = ""
-	var start uint64 = 0
-	var end uint64 = 0
 	var match bool
 
 	search_index = strconv.FormatUint(index, 10)
@@ -532,25 +810,56 @@ func get_table_key(index uint64, key uint64) (string, string) {
 	search_index = search_index + strconv.FormatUint(key, 10)
 	search_index = search_index + "-"
 
-	search_index_len = uint64(len(search_index))
+	search_index_len = len(search_index)
 
 	// DEBUG
 	// fmt.Println ("get_table_key: " + search_index)
 
-	dmutex.Lock()
-	for i = 0; i < maxdata; i++ {
-		if (*pdata)[i].used {
-			match = strings.Contains((*pdata)[i].key, search_index)
-			if match {
-				// fmt.Println ("get_table_key: found match!")
-				start = uint64(search_index_len)
-				end = uint64(len((*pdata)[i].key))
-				keystr = (*pdata)[i].key[start:end]
-				dmutex.Unlock()
-				return keystr, (*pdata)[i].value
-			}
+	for i = 0; i < len(snapshot); i++ {
+		match = strings.HasPrefix(snapshot[i].key, search_index)
+		if match {
+			// fmt.Println ("get_table_key: found match!")
+			keystr = snapshot[i].key[search_index_len:]
+			return keystr, snapshot[i].value
 		}
 	}
-	dmutex.Unlock()
 	return "", ""
 }
+
+// get a copy of all used data entries, at one point in time.
+// So a save is consistent while other clients write data
+func get_data_snapshot(db *database) []data {
+	db.mutex.RLock()
+	snapshot := get_data_snapshot_locked(db)
+	db.mutex.RUnlock()
+	return snapshot
+}
+
+// get the history depth, the queue counters and a copy of all used data entries for a save, at one point in time
+func get_save_snapshot(db *database) (int, map[string]queue_counter, []data) {
+	db.mutex.RLock()
+	history_depth := db.history_depth
+	queues := get_queue_snapshot_locked(db)
+	snapshot := get_data_snapshot_locked(db)
+	db.mutex.RUnlock()
+	return history_depth, queues, snapshot
+}
+
+// db.mutex must be locked
+func get_data_snapshot_locked(db *database) []data {
+	var i uint64
+
+	now := get_time_ms()
+	snapshot := make([]data, 0, len(db.key_index))
+	for i = 0; i < uint64(len(db.data)); i++ {
+		if is_used(&db.data[i], now) {
+			entry := db.data[i]
+			entry.links = append([]string(nil), db.data[i].links...)
+			entry.history = append([]history_entry(nil), db.data[i].history...)
+			entry.list = append([]string(nil), db.data[i].list...)
+			entry.reserved = append([]reserved_message(nil), db.data[i].reserved...)
+			snapshot = append(snapshot, entry)
+		}
+	}
+	return snapshot
+}
@@ -0,0 +1,186 @@
+// datafunc_test.go - database in go
+/*
+ * This file datafunc_test.go is part of L1VMgodata.
+ *
+ * (c) Copyright Stefan Pietzonke (jay-t@gmx.net), 2025
+ *
+ * L1VMgodata is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * L1VMgodata is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with L1VMgodata.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+// mixed read/write load on two databases, run with: go test -race ./...
+// The clients store, get, remove, link, push to lists, reserve queue messages and commit transactions at the same time.
+// A small max memory evicts keys of both databases.
+// At the end the key index, the links and the memory must match the data entries.
+
+package main
+
+import (
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+const (
+	LOAD_CLIENTS = 8
+	LOAD_ROUNDS  = 2000
+	LOAD_KEYS    = 50
+)
+
+func TestDataMixedLoad(t *testing.T) {
+	var wg sync.WaitGroup
+
+	dbs := []*database{new_database("load_test"), new_database("load_other")}
+	databases_mutex.Lock()
+	for _, db := range dbs {
+		set_database_map(db.name, db)
+	}
+	databases_mutex.Unlock()
+	max_memory = 16 * 1024
+	max_memory_policy = EVICTION_LRU
+	defer func() {
+		max_memory = 0
+		max_memory_policy = EVICTION_NOEVICTION
+		databases_mutex.Lock()
+		for _, db := range dbs {
+			set_database_map(db.name, nil)
+		}
+		databases_mutex.Unlock()
+	}()
+
+	for c := 0; c < LOAD_CLIENTS; c++ {
+		wg.Add(1)
+		go func(c int) {
+			defer wg.Done()
+			for n := 0; n < LOAD_ROUNDS; n++ {
+				db := dbs[(c+n/100)%2]
+				key := "key-" + strconv.Itoa((c*7+n)%LOAD_KEYS)
+				other := "key-" + strconv.Itoa((c+n*3)%LOAD_KEYS)
+				list := "list-" + strconv.Itoa(n%5)
+				queue := "queue-" + strconv.Itoa(n%3)
+
+				switch (c + n) % 14 {
+				case 0, 1:
+					store_data(db, key, "value "+strconv.Itoa(n))
+				case 2:
+					get_data_key(db, key)
+					get_data_value(db, "value "+strconv.Itoa(n))
+				case 3:
+					set_link(db, key, other)
+				case 4:
+					get_number_of_links(db, key)
+					get_link(db, key, 0)
+				case 5:
+					remove_link(db, key, other)
+				case 6:
+					remove_data(db, key)
+				case 7:
+					push_list(db, list, "element "+strconv.Itoa(n), n%2 == 0)
+				case 8:
+					pop_list(db, list, n%2 == 0)
+					get_list_range(db, list, 0, -1)
+				case 9:
+					get_data_list_key_prefix(db, "key-1", 10, 0)
+					get_used_elements(db)
+					get_data_snapshot(db)
+					get_memory_usage()
+				case 10:
+					push_list(db, queue, "message "+strconv.Itoa(n), false)
+					err, id, _ := reserve_queue(db, queue, uint64(n%2))
+					if err == LIST_OK && n%3 == 0 {
+						ack_queue(db, queue, id)
+					} else if err == LIST_OK && n%3 == 1 {
+						nack_queue(db, queue, id)
+					}
+				case 11:
+					release_expired(db)
+					get_queue_stats(db, queue)
+					peek_queue(db, queue)
+					if n%50 == 0 {
+						remove_data(db, queue)
+					}
+				case 12, 13:
+					// a transaction watching a key of the other database, an unknown message id fails it
+					var tr transaction
+					watch_key(&tr, dbs[(c+n/100+1)%2], other)
+					tr.active = true
+					tr.commands = []string{STORE_DATA + " :" + key + " 'commit " + strconv.Itoa(n) + "'",
+						INCR_DATA + " :counter", LIST_RPUSH + " :" + queue + " 'message " + strconv.Itoa(n) + "'",
+						QUEUE_RESERVE + " :" + queue + " '30'"}
+					if n%4 == 0 {
+						tr.commands = append(tr.commands, QUEUE_ACK+" :"+queue+" '1'")
+					}
+					commit_transaction(&tr, db)
+				}
+			}
+		}(c)
+	}
+
+	// a save while the clients write
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if save_data(dbs[0], filepath.Join(t.TempDir(), "load_test.l1db")) != 0 {
+			t.Error("save failed")
+		}
+	}()
+	wg.Wait()
+
+	for _, db := range dbs {
+		check_database(t, db)
+	}
+}
+
+// check the key index, the links and the memory of a database
+func check_database(t *testing.T, db *database) {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	used := 0
+	for i := range db.data {
+		d := &db.data[i]
+		if !d.used {
+			continue
+		}
+		used++
+		k, ok := db.key_index[d.key]
+		if !ok || k != uint64(i) {
+			t.Fatalf("key %s is not in the key index", d.key)
+		}
+		for _, link := range d.links {
+			l, ok := db.key_index[link]
+			if !ok {
+				t.Fatalf("key %s has a link to the removed key %s", d.key, link)
+			}
+			found := false
+			for _, back := range db.data[l].linked_by {
+				if back == d.key {
+					found = true
+				}
+			}
+			if !found {
+				t.Fatalf("key %s has no reverse link to %s", link, d.key)
+			}
+		}
+	}
+	if used != len(db.key_index) {
+		t.Fatalf("%d used entries, %d keys in the key index", used, len(db.key_index))
+	}
+
+	memory := db.memory
+	count_memory(db)
+	if memory != db.memory {
+		t.Fatalf("memory is %d, counted %d", memory, db.memory)
+	}
+}
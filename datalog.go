@@ -0,0 +1,649 @@
+// datalog.go - database in go
+/*
+ * This file datalog.go is part of L1VMgodata.
+ *
+ * (c) Copyright Stefan Pietzonke (jay-t@gmx.net), 2025
+ *
+ * L1VMgodata is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * L1VMgodata is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with L1VMgodata.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+// append-only data log: every data change is written to the log file.
+// On start the log is replayed, so no data is lost if the server crashes.
+// One entry per line, the arguments are quoted:
+// store "key" "value" "<update time in unix milliseconds>"
+// expire "key" "<unix time in milliseconds>"
+// meta "key" "<created>" "<updated>" "<version>"
+// history-depth "<depth>"
+// history "key" "<version>" "<updated>" "old value" ["type"]
+// type "key" "<type>"
+// lpush "key" "element" "<updated>", rpush "key" "element" "<updated>"
+// lpop "key" "<updated>", rpop "key" "<updated>"
+// lrem "key" "<count>" "element" "<updated>"
+// reserve "key" "<id>" "<deadline>" "<updated>", ack "key" "<id>" "<updated>", release "key" "<id>" "<updated>"
+// reserved "key" "<id>" "<deadline>" "element", queue "key" "<last id>" "<delivered>" "<acked>" "<redelivered>"
+// The entries after a "use" entry change the data of that database, before it the default database.
+
+package main
+
+import (
+	"bufio"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	LOG_HEADER = "l1vmgodata log"
+
+	// log entries
+	LOG_STORE   = "store"
+	LOG_REMOVE  = "remove"
+	LOG_LINK    = "link"
+	LOG_UNLINK  = "unlink"
+	LOG_ERASE   = "erase"
+	LOG_USE     = "use"
+	LOG_CREATE  = "create"
+	LOG_DROP    = "drop"
+	LOG_EXPIRE  = "expire"
+	LOG_PERSIST = "persist"
+	LOG_META    = "meta"
+
+	LOG_HISTORY_DEPTH = "history-depth"
+	LOG_HISTORY       = "history"
+	LOG_TYPE          = "type"
+
+	LOG_LPUSH = "lpush"
+	LOG_RPUSH = "rpush"
+	LOG_LPOP  = "lpop"
+	LOG_RPOP  = "rpop"
+	LOG_LREM  = "lrem"
+
+	LOG_RESERVE  = "reserve"
+	LOG_ACK      = "ack"
+	LOG_RELEASE  = "release"
+	LOG_RESERVED = "reserved"
+	LOG_QUEUE    = "queue"
+
+	// fsync settings
+	LOG_FSYNC_ALWAYS   = "always"
+	LOG_FSYNC_EVERYSEC = "everysec"
+	LOG_FSYNC_NEVER    = "never"
+)
+
+var log_file *os.File = nil   // nil if the data log is off or replayed
+var log_file_path string = "" // set by ":log-file" in settings.l1db
+var log_fsync string = LOG_FSYNC_EVERYSEC
+var log_changed bool = false // data written since last fsync
+var log_database string = "" // database of the last logged data change
+var lmutex sync.Mutex        // data log mutex
+
+// write one entry into the data log, db.mutex must be locked.
+// Every data change calls this, also if the data log is off.
+// So the entries are in the same order as the data changes.
+// db is nil for the entries which don't change the data of a database.
+func log_write(db *database, entry string, args ...string) {
+	var line string = entry
+
+	if db != nil && db.journal != nil {
+		// a transaction is running, the entries are written when it is committed
+		db.journal.log = append(db.journal.log, log_entry{entry: entry, args: args})
+		return
+	}
+
+	count_data_change()
+
+	if db != nil && is_dropped(db) {
+		// the database was dropped while a client used it
+		return
+	}
+
+	lmutex.Lock()
+	if log_file == nil {
+		lmutex.Unlock()
+		return
+	}
+
+	if db != nil && db.name != log_database {
+		line = LOG_USE + " " + strconv.Quote(db.name) + "\n" + line
+		log_database = db.name
+	}
+	for _, arg := range args {
+		line = line + " " + strconv.Quote(arg)
+	}
+	_, err := log_file.WriteString(line + "\n")
+	if err != nil {
+		print_message("log_write: Error writing data log: " + err.Error())
+	}
+
+	if log_fsync == LOG_FSYNC_ALWAYS {
+		err = log_file.Sync()
+		if err != nil {
+			print_message("log_write: Error syncing data log: " + err.Error())
+		}
+	} else {
+		log_changed = true
+	}
+	lmutex.Unlock()
+}
+
+// split a log entry line into the entry name and the arguments
+// returns 1 on error
+func split_log_entry(line string) (int, string, []string) {
+	var args []string
+	var entry string
+	var arg string
+	var pos int
+
+	pos = strings.Index(line, " ")
+	if pos == -1 {
+		return 0, line, nil
+	}
+	entry = line[:pos]
+	line = line[pos+1:]
+
+	for line != "" {
+		quoted, err := strconv.QuotedPrefix(line)
+		if err != nil {
+			return 1, "", nil
+		}
+		arg, err = strconv.Unquote(quoted)
+		if err != nil {
+			return 1, "", nil
+		}
+		args = append(args, arg)
+		line = strings.TrimPrefix(line[len(quoted):], " ")
+	}
+	return 0, entry, args
+}
+
+// run the log entries on the data, the log must not be open for writing
+// A broken last entry from a crash is cut off.
+// returns 1 on error
+func replay_log() int {
+	var offset int64 = 0
+	var header_line = 0
+	var entries uint64 = 0
+	var err int = 0
+	var entry string
+	var args []string
+	var db_name string = DEFAULT_DATABASE
+
+	file, ferr := os.Open(log_file_path)
+	if ferr != nil {
+		if os.IsNotExist(ferr) {
+			// no log yet
+			return 0
+		}
+		print_message("replay_log: Error opening data log: " + log_file_path + " " + ferr.Error())
+		return 1
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	for {
+		line, rerr := reader.ReadString('\n')
+		if rerr != nil {
+			if line != "" {
+				print_message("replay_log: broken last entry in data log, cut off!")
+				file.Close()
+				if os.Truncate(log_file_path, offset) != nil {
+					print_message("replay_log: Error cutting data log: " + log_file_path)
+					return 1
+				}
+			}
+			break
+		}
+
+		if header_line == 0 {
+			if line != LOG_HEADER+"\n" {
+				print_message("replay_log: Error " + log_file_path + " is not a l1vmgodata log!")
+				return 1
+			}
+			header_line = 1
+			offset = offset + int64(len(line))
+			continue
+		}
+
+		err, entry, args = split_log_entry(strings.TrimSuffix(line, "\n"))
+		if err == 0 {
+			err = replay_log_entry(&db_name, entry, args)
+		}
+		if err != 0 {
+			print_message("replay_log: Error in data log entry: " + line)
+			return 1
+		}
+		offset = offset + int64(len(line))
+		entries++
+	}
+
+	print_message("replay_log: " + strconv.FormatUint(entries, 10) + " entries replayed from " + log_file_path)
+	return 0
+}
+
+// db_name is the database of the data changes, set by the "use" entries
+func replay_log_entry(db_name *string, entry string, args []string) int {
+	switch entry {
+	case LOG_USE:
+		if len(args) == 1 {
+			*db_name = args[0]
+			return 0
+		}
+		return 1
+	case LOG_CREATE:
+		if len(args) == 1 {
+			create_database(args[0])
+			return 0
+		}
+		return 1
+	case LOG_DROP:
+		if len(args) == 1 {
+			drop_database(args[0])
+			return 0
+		}
+		return 1
+	}
+
+	db := get_database(*db_name)
+	if db == nil {
+		print_message("replay_log: Error database " + *db_name + " doesn't exist!")
+		return 1
+	}
+
+	switch entry {
+	case LOG_STORE:
+		// logs of older versions have no update time
+		if len(args) == 2 {
+			return int(store_data(db, args[0], args[1]))
+		}
+		if len(args) == 3 {
+			if store_data(db, args[0], args[1]) != 0 {
+				return 1
+			}
+			return set_store_time(db, args[0], args[2])
+		}
+	case LOG_REMOVE:
+		if len(args) == 1 {
+			remove_data(db, args[0])
+			return 0
+		}
+	case LOG_LINK:
+		if len(args) == 2 {
+			set_link(db, args[0], args[1])
+			return 0
+		}
+	case LOG_UNLINK:
+		if len(args) == 2 {
+			remove_link(db, args[0], args[1])
+			return 0
+		}
+	case LOG_EXPIRE:
+		if len(args) == 2 {
+			expire, err := strconv.ParseInt(args[1], 10, 64)
+			if err != nil {
+				return 1
+			}
+			set_expire_time(db, args[0], expire)
+			return 0
+		}
+	case LOG_PERSIST:
+		if len(args) == 1 {
+			persist_data(db, args[0])
+			return 0
+		}
+	case LOG_HISTORY_DEPTH:
+		if len(args) == 1 {
+			depth, err := strconv.Atoi(args[0])
+			if err != nil {
+				return 1
+			}
+			return set_history_depth(db, depth)
+		}
+	case LOG_HISTORY:
+		// the type is set if the old value is not a string
+		if len(args) == 4 || len(args) == 5 {
+			err, entry := split_history_save(args[1] + " " + args[2] + " " + args[3])
+			if err != 0 {
+				return 1
+			}
+			if len(args) == 5 {
+				if check_type_name(args[4]) {
+					return 1
+				}
+				entry.vtype = args[4]
+			}
+			db.mutex.Lock()
+			i, ok := db.key_index[args[0]]
+			if ok {
+				add_history(db, i, entry)
+			}
+			db.mutex.Unlock()
+			return 0
+		}
+	case LOG_TYPE:
+		if len(args) == 2 {
+			if check_type_name(args[1]) {
+				return 1
+			}
+			db.mutex.Lock()
+			i, ok := db.key_index[args[0]]
+			if ok {
+				set_type(db, i, args[1])
+			}
+			db.mutex.Unlock()
+			return 0
+		}
+	case LOG_LPUSH, LOG_RPUSH:
+		// a rewritten log has no update times, the metadata follows
+		if len(args) == 2 || len(args) == 3 {
+			err, _ := push_list(db, args[0], args[1], entry == LOG_LPUSH)
+			if err == LIST_ERROR {
+				return 1
+			}
+			if len(args) == 3 {
+				return set_store_time(db, args[0], args[2])
+			}
+			return 0
+		}
+	case LOG_LPOP, LOG_RPOP:
+		// like "remove", a key which is not set anymore is skipped
+		if len(args) == 2 {
+			pop_list(db, args[0], entry == LOG_LPOP)
+			return set_store_time(db, args[0], args[1])
+		}
+	case LOG_LREM:
+		if len(args) == 4 {
+			count, perr := strconv.ParseInt(args[1], 10, 64)
+			if perr != nil {
+				return 1
+			}
+			remove_list(db, args[0], count, args[2])
+			return set_store_time(db, args[0], args[3])
+		}
+	case LOG_RESERVE:
+		if len(args) == 4 {
+			id, perr := strconv.ParseUint(args[1], 10, 64)
+			if perr != nil || id == 0 {
+				return 1
+			}
+			deadline, perr := strconv.ParseInt(args[2], 10, 64)
+			if perr != nil {
+				return 1
+			}
+			db.mutex.Lock()
+			reserve_queue_locked(db, args[0], id, deadline)
+			db.mutex.Unlock()
+			return set_store_time(db, args[0], args[3])
+		}
+	case LOG_ACK, LOG_RELEASE:
+		if len(args) == 3 {
+			id, perr := strconv.ParseUint(args[1], 10, 64)
+			if perr != nil {
+				return 1
+			}
+			if entry == LOG_ACK {
+				ack_queue(db, args[0], id)
+			} else {
+				nack_queue(db, args[0], id)
+			}
+			return set_store_time(db, args[0], args[2])
+		}
+	case LOG_RESERVED:
+		// a reserved message of a rewritten log
+		if len(args) == 4 {
+			err, message := split_reserved_save(args[1] + " " + args[2] + " " + args[3])
+			if err != 0 {
+				return 1
+			}
+			db.mutex.Lock()
+			err = add_reserved(db, args[0], message)
+			db.mutex.Unlock()
+			return err
+		}
+	case LOG_QUEUE:
+		if len(args) == 5 {
+			err, counter := split_queue_save(args[1] + " " + args[2] + " " + args[3] + " " + args[4])
+			if err != 0 {
+				return 1
+			}
+			db.mutex.Lock()
+			set_queue_counter(db, args[0], counter)
+			db.mutex.Unlock()
+			return 0
+		}
+	case LOG_META:
+		if len(args) == 4 {
+			err, created, updated, version := split_meta_save(args[1] + " " + args[2] + " " + args[3])
+			if err != 0 {
+				return 1
+			}
+			db.mutex.Lock()
+			i, ok := db.key_index[args[0]]
+			if ok {
+				set_meta(db, i, created, updated, version)
+			}
+			db.mutex.Unlock()
+			return 0
+		}
+	case LOG_ERASE:
+		if len(args) == 0 {
+			init_data(db)
+			return 0
+		}
+	}
+	return 1
+}
+
+// open the data log to append entries
+// returns 1 on error
+func open_log() int {
+	lmutex.Lock()
+	defer lmutex.Unlock()
+
+	file, err := os.OpenFile(log_file_path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		print_message("open_log: Error opening data log: " + log_file_path + " " + err.Error())
+		return 1
+	}
+
+	info, err := file.Stat()
+	if err == nil && info.Size() == 0 {
+		_, err = file.WriteString(LOG_HEADER + "\n")
+	}
+	if err != nil {
+		print_message("open_log: Error writing data log: " + log_file_path + " " + err.Error())
+		file.Close()
+		return 1
+	}
+	log_file = file
+	return 0
+}
+
+func close_log() {
+	lmutex.Lock()
+	if log_file != nil {
+		log_file.Sync()
+		log_file.Close()
+		log_file = nil
+	}
+	lmutex.Unlock()
+}
+
+// fsync the data log every second, for the "everysec" setting
+func sync_log_loop() {
+	for {
+		time.Sleep(time.Second)
+
+		lmutex.Lock()
+		if log_file != nil && log_changed {
+			err := log_file.Sync()
+			if err != nil {
+				print_message("sync_log_loop: Error syncing data log: " + err.Error())
+			}
+			log_changed = false
+		}
+		lmutex.Unlock()
+	}
+}
+
+// the data of an import is not logged entry by entry: the data log is rewritten after it
+func log_import() {
+	count_data_change()
+
+	lmutex.Lock()
+	log_on := log_file != nil
+	lmutex.Unlock()
+
+	if log_on {
+		rewrite_log()
+	}
+}
+
+// write a new compact data log from the data, and replace the old one
+// returns 1 on error
+func rewrite_log() int {
+	var i uint64
+	var l int
+	var line string
+	var temp_path string = log_file_path + ".tmp"
+	var last_database string = ""
+
+	// no data changes while the log is written, the databases are locked in the order of their names
+	databases_mutex.Lock()
+	defer databases_mutex.Unlock()
+	dbs := get_databases()
+	for _, db := range dbs {
+		db.mutex.RLock()
+		defer db.mutex.RUnlock()
+	}
+	lmutex.Lock()
+	defer lmutex.Unlock()
+
+	if log_file == nil {
+		print_message("rewrite_log: Error data log is off!")
+		return 1
+	}
+
+	file, err := os.Create(temp_path)
+	if err != nil {
+		print_message("rewrite_log: Error creating data log: " + temp_path + " " + err.Error())
+		return 1
+	}
+	writer := bufio.NewWriter(file)
+	writer.WriteString(LOG_HEADER + "\n")
+
+	// expired keys which are not removed yet are not written
+	now := get_time_ms()
+
+	for _, db := range dbs {
+		if db.name != DEFAULT_DATABASE {
+			writer.WriteString(LOG_CREATE + " " + strconv.Quote(db.name) + "\n")
+		}
+		writer.WriteString(LOG_USE + " " + strconv.Quote(db.name) + "\n")
+		last_database = db.name
+		if db.history_depth > 0 {
+			writer.WriteString(LOG_HISTORY_DEPTH + " " + strconv.Quote(strconv.Itoa(db.history_depth)) + "\n")
+		}
+
+		for i = 0; i < uint64(len(db.data)); i++ {
+			if is_used(&db.data[i], now) {
+				if db.data[i].vtype == TYPE_LIST {
+					// a list is pushed element by element
+					for _, element := range db.data[i].list {
+						line = LOG_RPUSH + " " + strconv.Quote(db.data[i].key) + " " + strconv.Quote(element) + "\n"
+						writer.WriteString(line)
+					}
+					// a reserved message sets the list, also if it has no elements
+					for _, message := range db.data[i].reserved {
+						line = LOG_RESERVED + " " + strconv.Quote(db.data[i].key) + " " + strconv.Quote(strconv.FormatUint(message.id, 10)) + " " +
+							strconv.Quote(strconv.FormatInt(message.deadline, 10)) + " " + strconv.Quote(message.value) + "\n"
+						writer.WriteString(line)
+					}
+				} else {
+					line = LOG_STORE + " " + strconv.Quote(db.data[i].key) + " " + strconv.Quote(db.data[i].value) + "\n"
+					writer.WriteString(line)
+				}
+				line = LOG_META + " " + strconv.Quote(db.data[i].key) + " " + strconv.Quote(strconv.FormatInt(db.data[i].created, 10)) + " " +
+					strconv.Quote(strconv.FormatInt(db.data[i].updated, 10)) + " " + strconv.Quote(strconv.FormatUint(db.data[i].version, 10)) + "\n"
+				writer.WriteString(line)
+				if db.data[i].vtype != TYPE_STRING && db.data[i].vtype != TYPE_LIST {
+					line = LOG_TYPE + " " + strconv.Quote(db.data[i].key) + " " + strconv.Quote(db.data[i].vtype) + "\n"
+					writer.WriteString(line)
+				}
+				for _, entry := range db.data[i].history {
+					line = LOG_HISTORY + " " + strconv.Quote(db.data[i].key) + " " + strconv.Quote(strconv.FormatUint(entry.version, 10)) + " " +
+						strconv.Quote(strconv.FormatInt(entry.updated, 10)) + " " + strconv.Quote(entry.value)
+					if entry.vtype != TYPE_STRING {
+						line = line + " " + strconv.Quote(entry.vtype)
+					}
+					writer.WriteString(line + "\n")
+				}
+				if db.data[i].expire != 0 {
+					line = LOG_EXPIRE + " " + strconv.Quote(db.data[i].key) + " " + strconv.Quote(strconv.FormatInt(db.data[i].expire, 10)) + "\n"
+					writer.WriteString(line)
+				}
+			}
+		}
+		// the links are set after all keys are stored
+		for i = 0; i < uint64(len(db.data)); i++ {
+			if is_used(&db.data[i], now) {
+				for l = 0; l < len(db.data[i].links); l++ {
+					line = LOG_LINK + " " + strconv.Quote(db.data[i].key) + " " + strconv.Quote(db.data[i].links[l]) + "\n"
+					writer.WriteString(line)
+				}
+			}
+		}
+		// the counters of the queues, also of removed lists
+		for key, counter := range db.queues {
+			line = LOG_QUEUE + " " + strconv.Quote(key)
+			for _, number := range strings.Fields(format_queue_save(*counter)) {
+				line = line + " " + strconv.Quote(number)
+			}
+			writer.WriteString(line + "\n")
+		}
+	}
+
+	err = writer.Flush()
+	if err == nil {
+		err = file.Sync()
+	}
+	file.Close()
+	if err != nil {
+		print_message("rewrite_log: Error writing data log: " + temp_path + " " + err.Error())
+		os.Remove(temp_path)
+		return 1
+	}
+
+	err = os.Rename(temp_path, log_file_path)
+	if err != nil {
+		print_message("rewrite_log: Error renaming data log: " + temp_path + " " + err.Error())
+		os.Remove(temp_path)
+		return 1
+	}
+
+	// append to the new log
+	log_file.Close()
+	log_file, err = os.OpenFile(log_file_path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		print_message("rewrite_log: Error opening data log: " + log_file_path + " " + err.Error())
+		log_file = nil
+		return 1
+	}
+	log_changed = false
+	log_database = last_database
+
+	print_message("rewrite_log: data log " + log_file_path + " rewritten")
+	return 0
+}
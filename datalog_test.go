@@ -0,0 +1,203 @@
+// datalog_test.go - database in go
+/*
+ * This file datalog_test.go is part of L1VMgodata.
+ *
+ * (c) Copyright Stefan Pietzonke (jay-t@gmx.net), 2025
+ *
+ * L1VMgodata is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * L1VMgodata is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with L1VMgodata.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+// data log: the entries are split back into their arguments, and a written log is replayed into empty databases.
+// A broken last entry is cut off, the entries before it are replayed.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// values which must be quoted in the data log
+var log_test_values = []string{
+	"it's \"quoted\"",
+	"back\\slash \\n \\\"",
+	"line\nend\ttab\r",
+	"grüße 日本語 ✓",
+	"'",
+	"",
+}
+
+func TestSplitLogEntry(t *testing.T) {
+	tests := []struct {
+		line  string
+		err   int
+		entry string
+		args  []string
+	}{
+		{"erase", 0, "erase", nil},
+		{"store \"key\" \"value\"", 0, "store", []string{"key", "value"}},
+		{"store \"it's \\\"quoted\\\"\" \"back\\\\slash\"", 0, "store", []string{"it's \"quoted\"", "back\\slash"}},
+		{"store \"grüße\" \"\\u65e5\\u672c\" \"\"", 0, "store", []string{"grüße", "日本", ""}},
+		{"store \"key\" \"value", 1, "", nil},
+		{"store key \"value\"", 1, "", nil},
+		{"store \"bad \\q escape\"", 1, "", nil},
+	}
+
+	for _, test := range tests {
+		err, entry, args := split_log_entry(test.line)
+		if err != test.err || entry != test.entry || len(args) != len(test.args) {
+			t.Errorf("%q: got %d %q %q, expected %d %q %q", test.line, err, entry, args, test.err, test.entry, test.args)
+			continue
+		}
+		for a := range args {
+			if args[a] != test.args[a] {
+				t.Errorf("%q: argument %d is %q, expected %q", test.line, a+1, args[a], test.args[a])
+			}
+		}
+	}
+}
+
+// set up a new data log in a temp directory, the databases and the log settings are set back at the end
+func open_test_log(t *testing.T) {
+	saved_path := log_file_path
+	saved_database := log_database
+	t.Cleanup(func() {
+		close_log()
+		log_file_path = saved_path
+		log_database = saved_database
+		init_databases()
+	})
+
+	log_file_path = filepath.Join(t.TempDir(), "data.log")
+	log_database = ""
+	init_databases()
+	if open_log() != 0 {
+		t.Fatal("can't open the data log")
+	}
+}
+
+// get the value of a key without trimming it, returns false if the key is not set
+func get_log_test_value(db *database, key string) (string, bool) {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+	i, ok := get_key_index(db, key)
+	if !ok {
+		return "", false
+	}
+	return db.data[i].value, true
+}
+
+func TestReplayLog(t *testing.T) {
+	open_test_log(t)
+
+	db := get_database(DEFAULT_DATABASE)
+	for n, value := range log_test_values {
+		if store_data(db, "key "+log_test_values[n%3], value) != 0 {
+			t.Fatalf("can't store %q", value)
+		}
+		store_data(db, value+" key", value)
+	}
+	for _, value := range log_test_values {
+		push_list(db, "list", value, false)
+	}
+	if create_database("other") != 0 {
+		t.Fatal("can't create database other")
+	}
+	store_data(get_database("other"), "other", log_test_values[3])
+	close_log()
+
+	init_databases()
+	if replay_log() != 0 {
+		t.Fatal("replay failed")
+	}
+
+	db = get_database(DEFAULT_DATABASE)
+	for n, value := range log_test_values {
+		got, ok := get_log_test_value(db, value+" key")
+		if !ok || got != value {
+			t.Errorf("key %q is %q, expected %q", value+" key", got, value)
+		}
+		if n >= len(log_test_values)-3 {
+			// the last store of the key is kept
+			got, _ = get_log_test_value(db, "key "+log_test_values[n%3])
+			if got != value {
+				t.Errorf("key %q is %q, expected %q", "key "+log_test_values[n%3], got, value)
+			}
+		}
+	}
+	_, list := get_list_range(db, "list", 0, -1)
+	if len(list) != len(log_test_values) {
+		t.Fatalf("list has %d elements, expected %d", len(list), len(log_test_values))
+	}
+	for e, value := range log_test_values {
+		if list[e] != value {
+			t.Errorf("list element %d is %q, expected %q", e, list[e], value)
+		}
+	}
+	other := get_database("other")
+	if other == nil {
+		t.Fatal("database other is not replayed")
+	}
+	got, _ := get_log_test_value(other, "other")
+	if got != log_test_values[3] {
+		t.Errorf("key other is %q, expected %q", got, log_test_values[3])
+	}
+}
+
+func TestReplayLogBrokenLastEntry(t *testing.T) {
+	open_test_log(t)
+
+	db := get_database(DEFAULT_DATABASE)
+	store_data(db, "first", log_test_values[0])
+	store_data(db, "second", log_test_values[3])
+	close_log()
+
+	info, err := os.Stat(log_file_path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a crash while the last entry was written
+	file, err := os.OpenFile(log_file_path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteString("store \"third\" \"cut off")
+	file.Close()
+
+	init_databases()
+	if replay_log() != 0 {
+		t.Fatal("replay failed")
+	}
+
+	db = get_database(DEFAULT_DATABASE)
+	for key, value := range map[string]string{"first": log_test_values[0], "second": log_test_values[3]} {
+		got, ok := get_log_test_value(db, key)
+		if !ok || got != value {
+			t.Errorf("key %q is %q, expected %q", key, got, value)
+		}
+	}
+	_, ok := get_log_test_value(db, "third")
+	if ok {
+		t.Error("the broken entry is replayed")
+	}
+
+	cut, err := os.Stat(log_file_path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cut.Size() != info.Size() {
+		t.Errorf("data log has %d bytes after the cut, expected %d", cut.Size(), info.Size())
+	}
+}
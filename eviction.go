@@ -0,0 +1,312 @@
+// eviction.go - database in go
+/*
+ * This file eviction.go is part of L1VMgodata.
+ *
+ * (c) Copyright Stefan Pietzonke (jay-t@gmx.net), 2025
+ *
+ * L1VMgodata is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * L1VMgodata is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with L1VMgodata.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+// memory budget: the memory of all databases is counted.
+// If storing a key needs more than ":max-memory" bytes, keys are evicted by the ":max-memory-policy":
+// lru: the least recently used key, lfu: the least frequently used key,
+// ttl-first: the key which expires first, or lru if no key has a time to live,
+// noeviction: no key is evicted, the store fails.
+// Like Redis only a few random keys are compared, so the eviction is fast also with many keys.
+
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"unsafe"
+)
+
+const (
+	EVICTION_LRU        = "lru"
+	EVICTION_LFU        = "lfu"
+	EVICTION_TTL_FIRST  = "ttl-first"
+	EVICTION_NOEVICTION = "noeviction"
+
+	EVICTION_SAMPLES = 5 // keys compared to find the key to evict
+
+	// memory of a key without the strings: the data entry, the key index and a link
+	DATA_ENTRY_MEMORY = uint64(unsafe.Sizeof(data{})) + 48
+	LINK_MEMORY       = 16
+)
+
+var max_memory uint64 = 0 // max memory of the data in bytes, 0 = no limit
+var max_memory_policy string = EVICTION_NOEVICTION
+var evicted_keys uint64 = 0 // number of evicted keys, set atomic
+
+// get the memory setting in bytes, with an optional "kb", "mb" or "gb" at the end
+// returns 1 on error
+func get_memory_size(value string) (int, uint64) {
+	var unit uint64 = 1
+
+	value = strings.ToLower(strings.TrimSpace(value))
+	switch {
+	case strings.HasSuffix(value, "kb"):
+		unit = 1024
+	case strings.HasSuffix(value, "mb"):
+		unit = 1024 * 1024
+	case strings.HasSuffix(value, "gb"):
+		unit = 1024 * 1024 * 1024
+	}
+	if unit > 1 {
+		value = strings.TrimSpace(value[:len(value)-2])
+	}
+	size, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 1, 0
+	}
+	return 0, size * unit
+}
+
+// check the eviction policy setting, returns true if it is not known
+func check_eviction_policy(policy string) bool {
+	switch policy {
+	case EVICTION_LRU, EVICTION_LFU, EVICTION_TTL_FIRST, EVICTION_NOEVICTION:
+		return false
+	}
+	fmt.Println("Error eviction policy: " + policy + " is not known!")
+	return true
+}
+
+// get the memory of a data entry in bytes, db.mutex must be locked
+func entry_memory(d *data) uint64 {
+	var size uint64
+
+	size = DATA_ENTRY_MEMORY + 2*uint64(len(d.key)) + uint64(len(d.value))
+	for _, link := range d.links {
+		size = size + LINK_MEMORY + uint64(len(link))
+	}
+	for _, link := range d.linked_by {
+		size = size + LINK_MEMORY + uint64(len(link))
+	}
+	for _, entry := range d.history {
+		size = size + HISTORY_MEMORY + uint64(len(entry.value))
+	}
+	for _, element := range d.list {
+		size = size + LIST_MEMORY + uint64(len(element))
+	}
+	for _, message := range d.reserved {
+		size = size + RESERVED_MEMORY + uint64(len(message.value))
+	}
+	return size
+}
+
+// set the memory of a database after a data entry was changed, db.mutex must be locked.
+// before is the memory of the entry before the change
+func update_memory(db *database, i uint64, before uint64) {
+	set_memory(db, db.memory-before+entry_memory(&db.data[i]))
+}
+
+// set the memory of a database, db.mutex must be locked.
+// The memory of the other databases is read without their lock, so it is set atomic.
+func set_memory(db *database, memory uint64) {
+	atomic.StoreUint64(&db.memory, memory)
+}
+
+// count the memory of all data entries and queue counters of a database, db.mutex must be locked
+func count_memory(db *database) {
+	var i uint64
+	var memory uint64 = 0
+
+	for i = 0; i < uint64(len(db.data)); i++ {
+		if db.data[i].used {
+			memory = memory + entry_memory(&db.data[i])
+		}
+	}
+	for key := range db.queues {
+		memory = memory + QUEUE_MEMORY + uint64(len(key))
+	}
+	set_memory(db, memory)
+}
+
+// get the memory of all databases in bytes
+func get_total_memory() uint64 {
+	var total uint64 = 0
+
+	for _, db := range get_database_map() {
+		total = total + atomic.LoadUint64(&db.memory)
+	}
+	return total
+}
+
+// get the used memory of all databases and the number of evicted keys
+func get_memory_usage() (uint64, uint64) {
+	return get_total_memory(), atomic.LoadUint64(&evicted_keys)
+}
+
+// set the access time and count of a data entry, for the lru and lfu eviction.
+// Read commands only have the read lock, so the access is set atomic.
+func touch_data(db *database, i uint64) {
+	atomic.StoreInt64(&db.access[i], get_time_ms())
+	atomic.AddUint64(&db.hits[i], 1)
+}
+
+// evict keys until there are size bytes of memory free to store the key, db.mutex must be locked.
+// The key itself is not evicted. Keys of the database db are evicted first, then of the other ones.
+// A database used by another client is skipped. In a transaction only keys of db are evicted,
+// so the other databases don't need to be locked until the commit.
+// returns 1 if there is not enough memory
+func evict_data(db *database, key string, size uint64) int {
+	var i uint64
+	var ok bool
+
+	if max_memory == 0 || is_dropped(db) {
+		// no limit, or the memory of the settings or a dropped database
+		return 0
+	}
+
+	if get_total_memory()+size <= max_memory {
+		return 0
+	}
+
+	// the key itself is not evicted, if the store doesn't fit with all other keys evicted no key is evicted
+	var keep_memory uint64 = 0
+	i, ok = db.key_index[key]
+	if ok {
+		keep_memory = entry_memory(&db.data[i])
+	}
+	if size > max_memory || keep_memory+size > max_memory {
+		fmt.Println("error: max memory too small to store the key:", max_memory)
+		return 1
+	}
+
+	for get_total_memory()+size > max_memory {
+		if max_memory_policy == EVICTION_NOEVICTION {
+			fmt.Println("error: max memory used:", max_memory)
+			return 1
+		}
+
+		i, ok = select_eviction(db, key)
+		if ok {
+			evict_key(db, i)
+			if db.journal != nil {
+				db.journal.evicted++
+			}
+			continue
+		}
+		if db.journal == nil {
+			for _, other_db := range get_databases() {
+				if other_db == db || !other_db.mutex.TryLock() {
+					continue
+				}
+				if !is_dropped(other_db) {
+					i, ok = select_eviction(other_db, "")
+					if ok {
+						evict_key(other_db, i)
+					}
+				}
+				other_db.mutex.Unlock()
+				if ok {
+					break
+				}
+			}
+		}
+		if !ok {
+			fmt.Println("error: max memory used, no key to evict:", max_memory)
+			return 1
+		}
+	}
+	return 0
+}
+
+// remove an evicted data entry, db.mutex must be locked
+func evict_key(db *database, i uint64) {
+	key := db.data[i].key
+	delete_data(db, i)
+	remove_queue(db, key)
+	log_write(db, LOG_REMOVE, key)
+	atomic.AddUint64(&evicted_keys, 1)
+}
+
+// select the key to evict by the eviction policy, db.mutex must be locked.
+// Expired keys are evicted first. The key keep is never selected.
+// returns false if there is no key to evict
+func select_eviction(db *database, keep string) (uint64, bool) {
+	var i uint64
+	var best uint64
+	var found bool = false
+	var samples int = 0
+	var tries int = 0
+
+	now := get_time_ms()
+
+	if len(db.expiring) > 0 {
+		// the key which expires first, the map order is random
+		for key := range db.expiring {
+			if key == keep {
+				continue
+			}
+			i = db.key_index[key]
+			if !is_used(&db.data[i], now) {
+				return i, true
+			}
+			if max_memory_policy == EVICTION_TTL_FIRST && (!found || db.data[i].expire < db.data[best].expire) {
+				best = i
+				found = true
+			}
+			samples++
+			if samples == EVICTION_SAMPLES {
+				break
+			}
+		}
+		if found {
+			return best, true
+		}
+	}
+
+	_, keep_used := db.key_index[keep]
+	if len(db.key_index) == 0 || (len(db.key_index) == 1 && keep_used) {
+		return 0, false
+	}
+
+	// compare random data entries
+	samples = 0
+	for samples < EVICTION_SAMPLES && tries < EVICTION_SAMPLES*10 {
+		tries++
+		i = uint64(rand.Int63n(int64(len(db.data))))
+		if !db.data[i].used || db.data[i].key == keep {
+			continue
+		}
+		samples++
+		if !found || evict_before(db, i, best) {
+			best = i
+			found = true
+		}
+	}
+	if !found {
+		// most data entries are free, take the first used one
+		for i = 0; i < uint64(len(db.data)); i++ {
+			if db.data[i].used && db.data[i].key != keep {
+				return i, true
+			}
+		}
+	}
+	return best, found
+}
+
+// check if data entry i should be evicted before data entry j, db.mutex must be locked
+func evict_before(db *database, i uint64, j uint64) bool {
+	if max_memory_policy == EVICTION_LFU && db.hits[i] != db.hits[j] {
+		return db.hits[i] < db.hits[j]
+	}
+	return db.access[i] < db.access[j]
+}
@@ -28,10 +28,18 @@ import (
 	"crypto/sha256"
 	"fmt"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 )
 
+// headers of the database files
+const (
+	SAVE_HEADER         = "l1vmgodata database"
+	SAVE_HEADER_HISTORY = "l1vmgodata database history" // with the history depth and the old values of the keys
+	CSV_HEADER_TYPE     = "key, value, type"
+)
+
 func check_filename(file_path string) bool {
 	var ret bool
 	// if filepath contains "..", return 1
@@ -44,98 +52,222 @@ func check_filename(file_path string) bool {
 	return (ret)
 }
 
-func save_data(file_path string) int {
-	var i uint64 = 0
-	var l uint64 = 0
-	var linkslen uint64 = 0
+// all save and export functions write into a temp file in the same directory first.
+// It is renamed to the file name at the end, so the old file is kept if the server crashes or the disk is full.
+func create_save_file(file_path string) (*os.File, error) {
+	f, err := os.CreateTemp(filepath.Dir(file_path), filepath.Base(file_path)+".tmp-*")
+	if err != nil {
+		return nil, err
+	}
+	err = f.Chmod(0644)
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+	return f, nil
+}
+
+// write the temp file to disk and rename it to the file name
+// returns 1 on error
+func finish_save_file(f *os.File, file_path string) int {
+	err := f.Sync()
+	if err == nil {
+		err = f.Close()
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), file_path)
+	}
+	if err != nil {
+		fmt.Println("Error writing database file: " + file_path + " " + err.Error())
+		return 1
+	}
+
+	// write the rename to disk too
+	dir, err := os.Open(filepath.Dir(file_path))
+	if err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+	return 0
+}
+
+// remove the temp file if the save failed, call with defer.
+// After finish_save_file the temp file doesn't exist anymore.
+func remove_save_file(f *os.File) {
+	f.Close()
+	os.Remove(f.Name())
+}
+
+func save_data(db *database, file_path string) int {
+	var i int = 0
+	var l int = 0
+	var linkslen int = 0
 
 	if check_filename(file_path) == true {
 		return 1
 	}
 
-	os.Remove(file_path)
-	// create file
-	f, err := os.Create(file_path)
+	// get all data at one point in time
+	history_depth, queues, snapshot := get_save_snapshot(db)
+
+	// create temp file
+	f, err := create_save_file(file_path)
 	if err != nil {
 		fmt.Println("Error opening database file: " + file_path + err.Error())
 		return 1
 	}
-	// remember to close the file
-	defer f.Close()
-
-	// write header
-	_, err = f.WriteString("l1vmgodata database\n")
+	// remove the temp file on error
+	defer remove_save_file(f)
+
+	// write header, a database with a history has its own header and the history depth
+	if history_depth > 0 {
+		_, err = f.WriteString(SAVE_HEADER_HISTORY + "\n:history-depth \"" + strconv.Itoa(history_depth) + "\"\n")
+	} else {
+		_, err = f.WriteString(SAVE_HEADER + "\n")
+	}
 	if err != nil {
 		fmt.Println("Error writing database file:", err.Error())
 		return 1
 	}
 
+	// save the counters of the queues before the keys, they are kept also if a list is removed
+	for queue_key, counter := range queues {
+		_, err = f.WriteString(":queue" + " \"" + format_queue_save(counter) + " " + queue_key + "\"\n")
+		if err != nil {
+			fmt.Println("Error writing database file:", err.Error())
+			return 1
+		}
+	}
+
 	// write data loop
-	for i = 0; i < maxdata; i++ {
-		if (*pdata)[i].used {
-			dmutex.Lock()
-			value_save := strings.Trim((*pdata)[i].value, "'\n")
-			_, err = f.WriteString(":" + (*pdata)[i].key + " \"" + value_save + "\"\n")
+	for i = 0; i < len(snapshot); i++ {
+		value_save := strings.Trim(snapshot[i].value, "'\n")
+		_, err = f.WriteString(":" + snapshot[i].key + " \"" + value_save + "\"\n")
+		if err != nil {
+			fmt.Println("Error writing database file:", err.Error())
+			return 1
+		}
+
+		// save the metadata and the expire time, only set before the links number
+		_, err = f.WriteString(":meta" + " \"" + format_meta_save(&snapshot[i]) + "\"\n")
+		if err != nil {
+			fmt.Println("Error writing database file:", err.Error())
+			return 1
+		}
+		// save the type, if it is not a string
+		if snapshot[i].vtype != TYPE_STRING {
+			_, err = f.WriteString(":type" + " \"" + snapshot[i].vtype + "\"\n")
 			if err != nil {
 				fmt.Println("Error writing database file:", err.Error())
-				dmutex.Unlock()
 				return 1
 			}
-
-			// save links number
-			linkslen = uint64(len((*pdata)[i].links))
-			_, err = f.WriteString(":link" + " \"" + strconv.FormatInt(int64(linkslen), 10) + "\"\n")
-			dmutex.Unlock()
+		}
+		// save the elements of a list, head first, and the reserved messages of a queue
+		for _, element := range snapshot[i].list {
+			_, err = f.WriteString(":list" + " \"" + element + "\"\n")
+			if err != nil {
+				fmt.Println("Error writing database file:", err.Error())
+				return 1
+			}
+		}
+		for _, message := range snapshot[i].reserved {
+			_, err = f.WriteString(":reserved" + " \"" + format_reserved_save(message) + "\"\n")
+			if err != nil {
+				fmt.Println("Error writing database file:", err.Error())
+				return 1
+			}
+		}
+		// save the old values, oldest first. The type of an old value follows it
+		for _, entry := range snapshot[i].history {
+			entry.value = strings.Trim(entry.value, "'\n")
+			_, err = f.WriteString(":history" + " \"" + format_history_save(entry) + "\"\n")
+			if err == nil && entry.vtype != TYPE_STRING {
+				_, err = f.WriteString(":history-type" + " \"" + entry.vtype + "\"\n")
+			}
 			if err != nil {
 				fmt.Println("Error writing database file:", err.Error())
 				return 1
 			}
+		}
+		if snapshot[i].expire != 0 {
+			_, err = f.WriteString(":expire" + " \"" + strconv.FormatInt(snapshot[i].expire, 10) + "\"\n")
+			if err != nil {
+				fmt.Println("Error writing database file:", err.Error())
+				return 1
+			}
+		}
 
-			// save links
-			if linkslen > 0 {
-				for l = 0; l < linkslen; l++ {
-					dmutex.Lock()
-					_, err = f.WriteString(":link" + " \"" + (*pdata)[i].links[l] + "\"\n")
-					if err != nil {
-						fmt.Println("Error writing database file:", err.Error())
-						dmutex.Unlock()
-						return 1
-					}
-					dmutex.Unlock()
-				}
+		// save links number
+		linkslen = len(snapshot[i].links)
+		_, err = f.WriteString(":link" + " \"" + strconv.FormatInt(int64(linkslen), 10) + "\"\n")
+		if err != nil {
+			fmt.Println("Error writing database file:", err.Error())
+			return 1
+		}
+
+		// save links
+		for l = 0; l < linkslen; l++ {
+			_, err = f.WriteString(":link" + " \"" + snapshot[i].links[l] + "\"\n")
+			if err != nil {
+				fmt.Println("Error writing database file:", err.Error())
+				return 1
 			}
 		}
 	}
 
+	if finish_save_file(f, file_path) != 0 {
+		return 1
+	}
+	set_last_save()
+
 	fmt.Println("Log: database " + file_path + " saved!")
 	return 0
 }
 
-func load_data(file_path string) int {
+func load_data(db *database, file_path string) int {
 	var i uint64 = 0
+	var err int = 0
 	var header_line = 0
 	var key string
 	var value string
 	var l uint64 = 0
 	var linkslen uint64 = 0
+	var expire int64 = 0
+	var created int64 = 0
+	var updated int64 = 0
+	var version uint64 = 0
+	var key_line bool = false // last line was a key, the metadata, the expire time or the links number follow
+	var history bool = false  // the file has the history of the keys
+	var entry history_entry
+	var depth int = 0
+	var message reserved_message
+	var counter queue_counter
+	var queue_key string
+	var queue_line bool = true // the counters of the queues are before the first key
 
 	if check_filename(file_path) == true {
 		return 1
 	}
 
 	// load database file
-	file, err := os.Open(file_path)
-	if err != nil {
-		fmt.Println("Error opening database file: " + file_path + " " + err.Error())
+	file, ferr := os.Open(file_path)
+	if ferr != nil {
+		fmt.Println("Error opening database file: " + file_path + " " + ferr.Error())
 		return 1
 	}
 	// remember to close the file
 	defer file.Close()
 
-	// set i to data_index, so we can load more than one database. And don't start on zero index again!
-	i = data_index
+	// set the reverse links of the loaded links, also if the file is broken
+	defer func() {
+		db.mutex.Lock()
+		set_reverse_links(db)
+		db.mutex.Unlock()
+	}()
 
-	//fmt.Println("DEBUG: load: i start =", i)
+	// the data is stored into free data entries, so we can load more than one database.
+	// A key which is already set gets the value from the file.
 
 	// read and check header
 	scanner := bufio.NewScanner(file)
@@ -144,86 +276,213 @@ func load_data(file_path string) int {
 
 		//fmt.Println("DEBUG: i:", i, " line:", line)
 
-		if i < maxdata {
-			// enough data memory, load data
-			if header_line == 0 {
-				if line != "l1vmgodata database" {
-					fmt.Println("Error opening database file: " + file_path + " not a l1vmgodata database!")
+		if header_line == 0 {
+			if line != SAVE_HEADER && line != SAVE_HEADER_HISTORY {
+				fmt.Println("Error opening database file: " + file_path + " not a l1vmgodata database!")
+				return 1
+			}
+			history = line == SAVE_HEADER_HISTORY
+			header_line = 1
+		} else if history && header_line == 1 {
+			// the history depth follows the header
+			key, value = split_data(line)
+			depth, ferr = strconv.Atoi(value)
+			if key != "history-depth" || ferr != nil || set_history_depth(db, depth) != 0 {
+				fmt.Println("Error reading database: history depth is not valid: " + line)
+				return 1
+			}
+			header_line = 2
+		} else {
+			//fmt.Println("load_data: '" + line + "'\n")
+			key, value = split_data(line)
+
+			//fmt.Println("load_data: key: '" + key + "' value: '" + value + "'\n\n")
+
+			if key == "expire" && key_line {
+				// expire time of the key before
+				expire, ferr = strconv.ParseInt(value, 10, 64)
+				if ferr != nil {
+					fmt.Println("Error reading database: expire time is not a number: " + value)
 					return 1
 				}
-				header_line = 1
-			} else {
-				//fmt.Println("load_data: '" + line + "'\n")
-				key, value = split_data(line)
-
-				//fmt.Println("load_data: key: '" + key + "' value: '" + value + "'\n\n")
-
-				if key != "" && key != "link" {
-					// store data
-					dmutex.Lock()
-					(*pdata)[i].used = true
-					(*pdata)[i].key = key
-					(*pdata)[i].value = value
-					dmutex.Unlock()
+				db.mutex.Lock()
+				set_expire(db, i, expire)
+				db.mutex.Unlock()
+				continue
+			}
+			if key == "history" && key_line && history {
+				// old value of the key before
+				err, entry = split_history_save(value)
+				if err != 0 {
+					fmt.Println("Error reading database: history is not valid: " + value)
+					return 1
 				}
+				db.mutex.Lock()
+				add_history(db, i, entry)
+				db.mutex.Unlock()
+				continue
+			}
+			if key == "history-type" && key_line && history {
+				// type of the old value before
+				if check_type_name(value) {
+					return 1
+				}
+				db.mutex.Lock()
+				if len(db.data[i].history) > 0 {
+					db.data[i].history[len(db.data[i].history)-1].vtype = value
+				}
+				db.mutex.Unlock()
+				continue
+			}
+			if key == "list" && key_line {
+				// element of the list before, a key "list" is loaded as a key
+				db.mutex.Lock()
+				list := db.data[i].vtype == TYPE_LIST
+				if list {
+					add_list(db, i, value)
+				}
+				db.mutex.Unlock()
+				if list {
+					continue
+				}
+			}
+			if key == "queue" && queue_line {
+				// counters of a queue, a key "queue" is loaded as a key
+				err, queue_key, counter = split_queue_key_save(value)
+				if err == 0 {
+					db.mutex.Lock()
+					set_queue_counter(db, queue_key, counter)
+					db.mutex.Unlock()
+					continue
+				}
+			}
+			if (key == "reserved" || key == "queue") && key_line {
+				// reserved message or counters of the list before
+				db.mutex.Lock()
+				list := db.data[i].vtype == TYPE_LIST
+				if list && key == "reserved" {
+					err, message = split_reserved_save(value)
+					if err == 0 {
+						err = add_reserved(db, db.data[i].key, message)
+					}
+				} else if list {
+					err, counter = split_queue_save(value)
+					if err == 0 {
+						set_queue_counter(db, db.data[i].key, counter)
+					}
+				}
+				db.mutex.Unlock()
+				if list && err != 0 {
+					fmt.Println("Error reading database: queue is not valid: " + line)
+					return 1
+				}
+				if list {
+					continue
+				}
+			}
+			if key == "type" && key_line && value == TYPE_LIST {
+				// the key before is a list, its elements follow
+				db.mutex.Lock()
+				set_list(db, i, nil)
+				db.mutex.Unlock()
+				continue
+			}
+			if key == "type" && key_line {
+				// type of the key before, a key "type" of an older file is loaded as a key
+				if !check_type_name(value) {
+					db.mutex.Lock()
+					err, _ = check_value_type(value, db.data[i].value)
+					if err == 0 {
+						set_type(db, i, value)
+					}
+					db.mutex.Unlock()
+					if err != 0 {
+						fmt.Println("Error reading database: value doesn't have the type: " + value)
+						return 1
+					}
+					continue
+				}
+			}
+			if key == "meta" && key_line {
+				// metadata of the key before, files of older versions have none.
+				// A key "meta" of an older file is loaded as a key
+				err, created, updated, version = split_meta_save(value)
+				if err == 0 {
+					db.mutex.Lock()
+					set_meta(db, i, created, updated, version)
+					db.mutex.Unlock()
+					continue
+				}
+			}
+			key_line = false
 
-				if key == "link" {
-					// get links number
+			if key != "" && key != "link" {
+				queue_line = false
+				// store data
+				db.mutex.Lock()
+				err, i = set_data(db, key, value)
+				if err == 0 {
+					// the links are loaded from the file
+					db.data[i].links = nil
+					key_line = true
+				}
+				db.mutex.Unlock()
+				if err == 1 {
+					fmt.Println("Error reading database: out of memory: entries overflow!")
+					fmt.Println("Failed to load key:", key, "into maxdata:", maxdata)
+					return 1
+				}
+			}
 
-					linkslen, _ = strconv.ParseUint(value, 10, 64)
+			if key == "link" {
+				// get links number
 
-					//fmt.Printf ("load: links: %d\n", linkslen)
+				linkslen, _ = strconv.ParseUint(value, 10, 64)
 
-					if linkslen > 0 {
-						// there are links, load them
-						for l = 0; l < linkslen; l++ {
-							scanner.Scan()
-							line := scanner.Text()
-							key, value = split_data(line)
-							// link, _ = strconv.ParseUint(value, 10, 64)
+				//fmt.Printf ("load: links: %d\n", linkslen)
 
-							//fmt.Printf("load: link: %d\n", link)
+				if linkslen > 0 {
+					// there are links, load them
+					for l = 0; l < linkslen; l++ {
+						scanner.Scan()
+						line := scanner.Text()
+						key, value = split_data(line)
 
-							dmutex.Lock()
-							(*pdata)[i].links = append((*pdata)[i].links, value)
-							dmutex.Unlock()
+						db.mutex.Lock()
+						db.data[i].links = append(db.data[i].links, value)
+						db.mutex.Unlock()
 
-							// DEBUG
-							fmt.Println("got link\n")
-						}
+						// DEBUG
+						fmt.Println("got link")
 					}
-					i++
 				}
 			}
-		} else {
-			fmt.Println("Error reading database: out of memory: entries overflow!")
-			fmt.Println("Failed to load index:", i, "into maxdata:", maxdata)
-			return 1
 		}
 	}
-	data_index = i
-	free_index = i // set next free index
 
 	fmt.Println("Log: database " + file_path + " loaded!")
 	return 0
 }
 
 // export to .json data file
-func save_data_json(file_path string) int {
-	var i uint64 = 0
+func save_data_json(db *database, file_path string) int {
+	var i int = 0
 
 	if check_filename(file_path) == true {
 		return 1
 	}
 
-	// create file
-	f, err := os.Create(file_path)
+	// get all data at one point in time
+	snapshot := get_data_snapshot(db)
+
+	// create temp file
+	f, err := create_save_file(file_path)
 	if err != nil {
 		fmt.Println("Error opening database file: " + file_path + err.Error())
 		return 1
 	}
-	// remember to close the file
-	defer f.Close()
+	// remove the temp file on error
+	defer remove_save_file(f)
 
 	// write header
 	_, err = f.WriteString("{ \"l1vmgodata database\" :[\n")
@@ -233,29 +492,45 @@ func save_data_json(file_path string) int {
 	}
 
 	// write data loop
-	for i = 0; i < maxdata; i++ {
-		if (*pdata)[i].used {
-			dmutex.Lock()
-			value_save := strings.Trim((*pdata)[i].value, "\n")
-			_, err = f.WriteString("{ \"key\": \"" + (*pdata)[i].key + "\", \"value\": \"" + value_save + "\" },\n")
-			dmutex.Unlock()
+	for i = 0; i < len(snapshot); i++ {
+		if i > 0 {
+			// no comma after the last entry, to create valid json file
+			_, err = f.WriteString(",\n")
 			if err != nil {
 				fmt.Println("Error writing database file:", err.Error())
 				return 1
 			}
 		}
+		value_save := strings.Trim(snapshot[i].value, "\n")
+		expire_save := ""
+		if snapshot[i].expire != 0 {
+			expire_save = ", \"expire\": " + strconv.FormatInt(snapshot[i].expire, 10)
+		}
+		type_save := ""
+		if snapshot[i].vtype != TYPE_STRING {
+			type_save = ", \"type\": \"" + snapshot[i].vtype + "\""
+		}
+		meta_save := ", \"created\": " + strconv.FormatInt(snapshot[i].created, 10) + ", \"updated\": " + strconv.FormatInt(snapshot[i].updated, 10) +
+			", \"version\": " + strconv.FormatUint(snapshot[i].version, 10)
+		if snapshot[i].vtype == TYPE_LIST {
+			value_save = format_list_json(snapshot[i].list)
+		} else {
+			value_save = format_value_json(value_save, snapshot[i].vtype)
+		}
+		_, err = f.WriteString("{ \"key\": " + quote_json(snapshot[i].key) + ", \"value\": " + value_save + type_save + expire_save + meta_save + " }")
+		if err != nil {
+			fmt.Println("Error writing database file:", err.Error())
+			return 1
+		}
 	}
 
-	// remove last comma to create valid json file:
-	_, err = f.Seek(-2, 1)
+	_, err = f.WriteString("\n]\n}\n")
 	if err != nil {
-		fmt.Println("Error seeking database file:", err.Error())
+		fmt.Println("Error writing database file:", err.Error())
 		return 1
 	}
 
-	_, err = f.WriteString("\n]\n}\n")
-	if err != nil {
-		fmt.Println("Error writing database file:", err.Error())
+	if finish_save_file(f, file_path) != 0 {
 		return 1
 	}
 
@@ -264,8 +539,12 @@ func save_data_json(file_path string) int {
 }
 
 // import .json file
-func load_data_json(file_path string) int {
+func load_data_json(db *database, file_path string) int {
+	var err int = 0
 	var i uint64 = 0
+	var version uint64 = 0
+	var vtype string
+	var elements []string
 	var header_line = 0
 	var key string
 	var value string
@@ -275,156 +554,198 @@ func load_data_json(file_path string) int {
 	}
 
 	// open file
-	file, err := os.Open(file_path)
-	if err != nil {
-		fmt.Println("Error opening database file: " + file_path + " " + err.Error())
+	file, ferr := os.Open(file_path)
+	if ferr != nil {
+		fmt.Println("Error opening database file: " + file_path + " " + ferr.Error())
 		return 1
 	}
 	// remember to close the file
 	defer file.Close()
 
-	// set i to data_index, so we can load more than one database. And don't start on zero index again!
-	i = data_index
-
 	// read and check header
 	scanner := bufio.NewScanner(file)
 	for scanner.Scan() {
 		line := scanner.Text()
-		if i < maxdata {
-			// enough data memory, load data
-			if header_line == 0 {
-				if line != "{ \"l1vmgodata database\" :[" {
-					fmt.Println("Error opening database file: " + file_path + " not a json l1vmgodata database!")
+		if header_line == 0 {
+			if line != "{ \"l1vmgodata database\" :[" {
+				fmt.Println("Error opening database file: " + file_path + " not a json l1vmgodata database!")
+				return 1
+			}
+			header_line = 1
+		} else {
+			// fmt.Println("read: " + line)
+			key, value = split_data_json(line)
+			// fmt.Println("key: " +key + " value: " + value +"\n")
+
+			if key != "" {
+				// store data
+				vtype = split_type_json(line)
+				if vtype == TYPE_LIST {
+					// the value is a JSON array
+					err, elements = split_list_json(line)
+					value = ""
+				} else if check_type_name(vtype) {
 					return 1
+				} else {
+					err, value = check_value_type(vtype, value)
 				}
-				header_line = 1
-			} else {
-				// fmt.Println("read: " + line)
-				key, value = split_data_json(line)
-				// fmt.Println("key: " +key + " value: " + value +"\n")
-
-				if key != "" {
-					// store data
-					dmutex.Lock()
-					(*pdata)[i].used = true
-					(*pdata)[i].key = key
-					(*pdata)[i].value = value
-					dmutex.Unlock()
-					i++
+				if err != 0 {
+					fmt.Println("Error reading database: value doesn't have the type: " + line)
+					return 1
+				}
+				db.mutex.Lock()
+				err, i = set_data(db, key, value)
+				if err == 0 {
+					if vtype == TYPE_LIST {
+						set_list(db, i, elements)
+					} else {
+						set_type(db, i, vtype)
+					}
+					set_expire(db, i, split_number_json(line, "expire"))
+					// files of older versions have no metadata
+					version = uint64(split_number_json(line, "version"))
+					if version > 0 {
+						set_meta(db, i, split_number_json(line, "created"), split_number_json(line, "updated"), version)
+					}
+				}
+				db.mutex.Unlock()
+				if err == 1 {
+					fmt.Println("Error reading database: out of memory: entries overflow!")
+					return 1
 				}
 			}
-		} else {
-			fmt.Println("Error reading database: out of memory: entries overflow!")
-			return 1
 		}
 	}
-	data_index = i
 
 	fmt.Println("Log: database JSON " + file_path + " loaded!")
 	return 0
 }
 
 // export CSV
-func save_data_csv(file_path string) int {
-	var i uint64 = 0
+func save_data_csv(db *database, file_path string) int {
+	var i int = 0
 
 	if check_filename(file_path) == true {
 		return 1
 	}
 
-	// create file
-	f, err := os.Create(file_path)
+	// get all data at one point in time
+	snapshot := get_data_snapshot(db)
+
+	// create temp file
+	f, err := create_save_file(file_path)
 	if err != nil {
 		fmt.Println("Error opening database file: " + file_path + err.Error())
 		return 1
 	}
-	// remember to close the file
-	defer f.Close()
+	// remove the temp file on error
+	defer remove_save_file(f)
 
 	// write header
-	_, err = f.WriteString("key, value\n")
+	_, err = f.WriteString(CSV_HEADER_TYPE + "\n")
 	if err != nil {
 		fmt.Println("Error writing database file:", err.Error())
 		return 1
 	}
 
 	// write data loop
-	dmutex.Lock()
-	for i = 0; i < maxdata; i++ {
-		if (*pdata)[i].used {
-			value_save := strings.Trim((*pdata)[i].value, "'\n")
-			_, err = f.WriteString((*pdata)[i].key + ", " + value_save + "\n")
-			if err != nil {
-				fmt.Println("Error writing database file:", err.Error())
-				dmutex.Unlock()
-				return 1
-			}
+	for i = 0; i < len(snapshot); i++ {
+		if snapshot[i].vtype == TYPE_LIST {
+			// a list has no value for the value column
+			continue
+		}
+		value_save := strings.Trim(snapshot[i].value, "'\n")
+		_, err = f.WriteString(snapshot[i].key + ", " + value_save + ", " + snapshot[i].vtype + "\n")
+		if err != nil {
+			fmt.Println("Error writing database file:", err.Error())
+			return 1
 		}
 	}
-	dmutex.Unlock()
+
+	if finish_save_file(f, file_path) != 0 {
+		return 1
+	}
 
 	fmt.Println("Log: database CSV " + file_path + " saved!")
 	return 0
 }
 
-func load_data_csv(file_path string) int {
+func load_data_csv(db *database, file_path string) int {
+	var err int = 0
 	var i uint64 = 0
 	var header_line = 0
 	var key string
 	var value string
+	var vtype string = TYPE_STRING
+	var typed bool = false // the file has a type column
+	var pos int = 0
 
 	if check_filename(file_path) == true {
 		return 1
 	}
 
 	// load database file
-	file, err := os.Open(file_path)
-	if err != nil {
-		fmt.Println("Error opening database file: " + file_path + " " + err.Error())
+	file, ferr := os.Open(file_path)
+	if ferr != nil {
+		fmt.Println("Error opening database file: " + file_path + " " + ferr.Error())
 		return 1
 	}
 	// remember to close the file
 	defer file.Close()
 
-	// set i to data_index, so we can load more than one database. And don't start on zero index again!
-	i = data_index
-
 	// read and check header
 	scanner := bufio.NewScanner(file)
 	for scanner.Scan() {
 		line := scanner.Text()
-		if i < maxdata {
-			// enough data memory, load data
-			if header_line == 0 {
-				// skip header line
-				header_line = 1
-				continue
+		if header_line == 0 {
+			// skip header line, files of older versions have no type column
+			typed = line == CSV_HEADER_TYPE
+			header_line = 1
+			continue
+		}
+
+		// fmt.Println("read: " + line)
+		key, value = split_data_csv(line)
+		if key == "" {
+			continue
+		}
+		// the value is written after ", "
+		value = strings.TrimPrefix(value, " ")
+		if typed {
+			// the type is the last column, the value can have commas
+			pos = strings.LastIndex(value, ", ")
+			if pos == -1 || check_type_name(value[pos+2:]) {
+				fmt.Println("Error reading database: no type found: " + line)
+				return 1
+			}
+			vtype = value[pos+2:]
+			err, value = check_value_type(vtype, value[:pos])
+			if err != 0 {
+				fmt.Println("Error reading database: value doesn't have the type: " + line)
+				return 1
 			}
+		}
 
-			// fmt.Println("read: " + line)
-			key, value = split_data_csv(line)
-
-			//fmt.Println("load: key: " + key)
-			// store data
-			dmutex.Lock()
-			(*pdata)[i].used = true
-			(*pdata)[i].key = key
-			(*pdata)[i].value = value
-			dmutex.Unlock()
-		} else {
+		//fmt.Println("load: key: " + key)
+		// store data
+		db.mutex.Lock()
+		err, i = set_data(db, key, value)
+		if err == 0 {
+			set_type(db, i, vtype)
+		}
+		db.mutex.Unlock()
+		if err == 1 {
 			fmt.Println("Error reading database: out of memory: entries overflow!")
 			return 1
 		}
-
 	}
-	data_index = i
 
 	fmt.Println("Log: database JSON " + file_path + " loaded!")
 	return 0
 }
 
 // export CSV table
-func save_data_table_csv(file_path string) int {
+func save_data_table_csv(db *database, file_path string) int {
 	// save CSV table in the format of:
 	// :1-1-substance "water"
 	// :link '0'
@@ -456,18 +777,21 @@ func save_data_table_csv(file_path string) int {
 		return 1
 	}
 
-	// create file
-	f, err := os.Create(file_path)
+	// get all data at one point in time
+	snapshot := get_data_snapshot(db)
+
+	// create temp file
+	f, err := create_save_file(file_path)
 	if err != nil {
 		fmt.Println("Error opening database file: " + file_path + err.Error())
 		return 1
 	}
-	// remember to close the file
-	defer f.Close()
+	// remove the temp file on error
+	defer remove_save_file(f)
 
 	// get start key
 	for search {
-		keystr, valuestr = get_table_key(index, key)
+		keystr, valuestr = get_table_key(snapshot, index, key)
 
 		// DEBUG
 		//fmt.Printf("save tavble csv: index: %d, key: %d\n", index, key )
@@ -503,7 +827,7 @@ func save_data_table_csv(file_path string) int {
 	index = 1
 	for save {
 		for key = 1; key <= keymax; key++ {
-			keystr, valuestr = get_table_key(index, key)
+			keystr, valuestr = get_table_key(snapshot, index, key)
 			if keystr != "" {
 				if key > 1 {
 					_, err = f.WriteString(", ")
@@ -534,12 +858,16 @@ func save_data_table_csv(file_path string) int {
 		index++
 	}
 
+	if finish_save_file(f, file_path) != 0 {
+		return 1
+	}
+
 	fmt.Println("Log: database CSV table " + file_path + " saved!")
 	return 0
 }
 
 // import CSV table
-func load_data_table_csv(file_path string) int {
+func load_data_table_csv(db *database, file_path string) int {
 	var key uint64 = 1
 	var index uint64 = 1
 	var keyfullstr string = ""
@@ -549,7 +877,7 @@ func load_data_table_csv(file_path string) int {
 	var key_headerstr string = ""
 	var valuestr string = ""
 	var key_line = true
-	var i uint64
+	var err int = 0
 	var value_start int = 0
 	var value_next int = 0
 	//var value_comma_pos int
@@ -563,17 +891,14 @@ func load_data_table_csv(file_path string) int {
 	}
 
 	// load database file
-	file, err := os.Open(file_path)
-	if err != nil {
-		fmt.Println("Error opening database file: " + file_path + " " + err.Error())
+	file, ferr := os.Open(file_path)
+	if ferr != nil {
+		fmt.Println("Error opening database file: " + file_path + " " + ferr.Error())
 		return 1
 	}
 	// remember to close the file
 	defer file.Close()
 
-	// set i to data_index, so we can load more than one database. And don't start on zero index again!
-	i = data_index
-
 	scanner := bufio.NewScanner(file)
 	for scanner.Scan() {
 		line := scanner.Text()
@@ -615,15 +940,10 @@ func load_data_table_csv(file_path string) int {
 
 				//fmt.Println("csv table import: value: " + valuestr)
 
-				if i < maxdata {
-					dmutex.Lock()
-					(*pdata)[i].used = true
-					(*pdata)[i].key = keyfullstr
-					(*pdata)[i].value = valuestr
-					data_index = i
-					i++
-					dmutex.Unlock()
-				} else {
+				db.mutex.Lock()
+				err, _ = set_data(db, keyfullstr, valuestr)
+				db.mutex.Unlock()
+				if err == 1 {
 					fmt.Println("Error reading database: out of memory: entries overflow!")
 					return 1
 				}
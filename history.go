@@ -0,0 +1,207 @@
+// history.go - database in go
+/*
+ * This file history.go is part of L1VMgodata.
+ *
+ * (c) Copyright Stefan Pietzonke (jay-t@gmx.net), 2025
+ *
+ * L1VMgodata is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * L1VMgodata is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with L1VMgodata.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+// version history: a database with a history depth keeps the last values of every key.
+// A store moves the old value with its version and update time into the history of the key.
+// The value of a key at a point in time can be read, and a key can be set back to an old version.
+// The history is removed with the key.
+
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+const (
+	HISTORY_MAX_DEPTH = 1000 // max number of old values of a key
+	HISTORY_MEMORY    = 40   // memory of a history entry without the value
+)
+
+// an old value of a key
+type history_entry struct {
+	value   string
+	updated int64
+	version uint64
+	vtype   string
+}
+
+// move the value of a data entry into its history, before a new value is stored.
+// The elements of a list are not kept in the history. db.mutex must be locked
+func push_history(db *database, i uint64) {
+	if db.history_depth == 0 || db.data[i].vtype == TYPE_LIST {
+		return
+	}
+	d := &db.data[i]
+	d.history = cut_history(append(d.history, history_entry{value: d.value, updated: d.updated, version: d.version, vtype: d.vtype}), db.history_depth)
+}
+
+// cut a history to the newest depth entries
+func cut_history(history []history_entry, depth int) []history_entry {
+	if len(history) <= depth {
+		return history
+	}
+	if depth == 0 {
+		return nil
+	}
+	// copy the entries, so the old ones are freed
+	return append([]history_entry(nil), history[len(history)-depth:]...)
+}
+
+// set the history depth of a database, the history of all keys is cut to it.
+// returns 1 if the depth is too big
+func set_history_depth(db *database, depth int) int {
+	var i uint64
+
+	if depth < 0 || depth > HISTORY_MAX_DEPTH {
+		return 1
+	}
+
+	db.mutex.Lock()
+	db.history_depth = depth
+	for i = 0; i < uint64(len(db.data)); i++ {
+		if len(db.data[i].history) > depth {
+			change_entry(db, i)
+			before := entry_memory(&db.data[i])
+			db.data[i].history = cut_history(db.data[i].history, depth)
+			update_memory(db, i, before)
+		}
+	}
+	log_write(db, LOG_HISTORY_DEPTH, strconv.Itoa(depth))
+	db.mutex.Unlock()
+	return 0
+}
+
+// get the history depth of a database
+func get_history_depth(db *database) int {
+	db.mutex.RLock()
+	depth := db.history_depth
+	db.mutex.RUnlock()
+	return depth
+}
+
+// get the values of a key, the current value first and then the history, newest first
+// returns 1 if the key is not found
+func get_history(db *database, key string) (int, []history_entry) {
+	db.mutex.RLock()
+	err, entries := get_history_locked(db, key)
+	db.mutex.RUnlock()
+	return err, entries
+}
+
+// db.mutex must be locked
+func get_history_locked(db *database, key string) (int, []history_entry) {
+	var h int
+
+	i, ok := get_key_index(db, key)
+	if !ok {
+		return 1, nil
+	}
+	d := &db.data[i]
+	entries := []history_entry{{value: d.value, updated: d.updated, version: d.version, vtype: d.vtype}}
+	for h = len(d.history) - 1; h >= 0; h-- {
+		entries = append(entries, d.history[h])
+	}
+	return 0, entries
+}
+
+// get the value a key had at the time in unix milliseconds
+// returns 1 if the key is not found or the time is before the oldest value in the history
+func get_data_key_at(db *database, key string, ms int64) (int, string) {
+	db.mutex.RLock()
+	err, value := get_data_key_at_locked(db, key, ms)
+	db.mutex.RUnlock()
+	return err, value
+}
+
+// db.mutex must be locked
+func get_data_key_at_locked(db *database, key string, ms int64) (int, string) {
+	err, entries := get_history_locked(db, key)
+	if err != 0 {
+		return 1, ""
+	}
+	for _, entry := range entries {
+		if entry.updated <= ms {
+			return 0, strings.Trim(entry.value, "'\n")
+		}
+	}
+	return 1, ""
+}
+
+// store the value of an old version of a key as a new version
+// returns 1 if the key or the version is not found
+func revert_data(db *database, key string, version uint64) int {
+	db.mutex.Lock()
+	err := revert_data_locked(db, key, version)
+	db.mutex.Unlock()
+	return err
+}
+
+// db.mutex must be locked
+func revert_data_locked(db *database, key string, version uint64) int {
+	err, entries := get_history_locked(db, key)
+	if err != 0 {
+		return 1
+	}
+	for _, entry := range entries {
+		if entry.version == version {
+			return store_data_type_locked(db, key, entry.value, entry.vtype)
+		}
+	}
+	return 1
+}
+
+// add an old value to the history of a key, for loading the history.
+// db.mutex must be locked
+func add_history(db *database, i uint64, entry history_entry) {
+	change_entry(db, i)
+	before := entry_memory(&db.data[i])
+	db.data[i].history = cut_history(append(db.data[i].history, entry), db.history_depth)
+	update_memory(db, i, before)
+}
+
+// the history entry in the save file and the data log: "version updated value"
+func format_history_save(entry history_entry) string {
+	return strconv.FormatUint(entry.version, 10) + " " + strconv.FormatInt(entry.updated, 10) + " " + entry.value
+}
+
+// get the history entry of a save file line ":history "version updated value""
+// returns 1 on error
+func split_history_save(input string) (int, history_entry) {
+	var entry history_entry
+	var err error
+
+	entry.vtype = TYPE_STRING
+
+	fields := strings.SplitN(input, " ", 3)
+	if len(fields) != 3 {
+		return 1, entry
+	}
+	entry.version, err = strconv.ParseUint(fields[0], 10, 64)
+	if err != nil {
+		return 1, entry
+	}
+	entry.updated, err = strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return 1, entry
+	}
+	entry.value = fields[2]
+	return 0, entry
+}
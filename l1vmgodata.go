@@ -24,14 +24,19 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"crypto/tls"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"net"
 	"os"
+	"os/signal"
 	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 )
 
@@ -45,9 +50,17 @@ const (
 	STORE_DATA            = "store data"
 	STORE_DATA_NEW        = "store data new"
 	GET_DATA_KEY          = "get key"
+	GET_DATA_KEY_CONTAINS = "get contains key"
+	GET_DATA_KEY_PREFIX   = "get prefix key"
 	GET_DATA_VALUE        = "get value"
 	GET_DATA_REGEXP_KEY   = "get regex key"
 	GET_DATA_REGEXP_VALUE = "get regex value"
+	LIST_KEY_CONTAINS     = "list contains key"
+	LIST_KEY_PREFIX       = "list prefix key"
+	LIST_VALUE            = "list value"
+	LIST_REGEXP_KEY       = "list regex key"
+	LIST_REGEXP_VALUE     = "list regex value"
+	SCAN_DATA             = "scan"
 	REMOVE_DATA           = "remove"
 	CLOSE_CONNECTION      = "close"
 	SAVE_DATA             = "save"
@@ -64,6 +77,49 @@ const (
 	REMOVE_LINK           = "rem-link"
 	GET_LINKS_NUMBER      = "get-links-number"
 	GET_LINK_NAME         = "get-link-name"
+	REWRITE_LOG           = "rewrite-log"
+	LAST_SAVE             = "lastsave"
+	CREATE_DATABASE       = "create db"
+	USE_DATABASE          = "use db"
+	DROP_DATABASE         = "drop db"
+	LIST_DATABASES        = "list dbs"
+	STORE_DATA_TTL        = "store data ttl"
+	EXPIRE_DATA           = "expire"
+	GET_TTL               = "ttl"
+	PERSIST_DATA          = "persist"
+	INCR_FLOAT_DATA       = "incrfloat"
+	INCR_DATA             = "incr"
+	DECR_DATA             = "decr"
+	STORE_DATA_IF_ABSENT  = "store data if-absent"
+	STORE_DATA_IF_PRESENT = "store data if-present"
+	CAS_DATA              = "cas"
+	STORE_DATA_IF_VERSION = "store data if-version"
+	GET_META              = "meta"
+	HISTORY_DEPTH         = "history-depth"
+	GET_HISTORY           = "history"
+	GET_DATA_KEY_AT       = "get key at"
+	REVERT_DATA           = "revert"
+	STORE_DATA_TYPE       = "store data type"
+	GET_TYPE              = "type"
+	LIST_COMPARE          = "list compare"
+	LIST_LPUSH            = "lpush"
+	LIST_RPUSH            = "rpush"
+	LIST_LPOP             = "lpop"
+	LIST_RPOP             = "rpop"
+	LIST_RANGE            = "lrange"
+	LIST_LENGTH           = "llen"
+	LIST_REMOVE           = "lrem"
+	LIST_BLPOP            = "blpop"
+	LIST_BRPOP            = "brpop"
+	QUEUE_RESERVE         = "reserve"
+	QUEUE_ACK             = "ack"
+	QUEUE_NACK            = "nack"
+	QUEUE_PEEK            = "peek"
+	QUEUE_STATS           = "stats"
+	BEGIN_TRANSACTION     = "begin"
+	COMMIT_TRANSACTION    = "commit"
+	DISCARD_TRANSACTION   = "discard"
+	WATCH_KEY             = "watch"
 	EXIT                  = "exit"
 	AUTH                  = "login"
 )
@@ -75,21 +131,30 @@ const (
 	SETTINGS  = "config/settings.l1db"
 )
 
+// start size of the data slice, it grows if needed
+const DATA_START_SIZE = 1024
+
 type data struct {
-	used  bool
-	key   string
-	value string
-	links []string
+	used      bool
+	key       string
+	value     string
+	links     []string
+	linked_by []string           // keys which have a link to this key
+	expire    int64              // unix time in milliseconds when the key is removed, 0 = never
+	changed   uint64             // change number of the last change, for "watch"
+	created   int64              // unix time in milliseconds when the key was stored first
+	updated   int64              // unix time in milliseconds when the value was stored last
+	version   uint64             // number of stores of the value, 1 for a new key
+	history   []history_entry    // old values, oldest first
+	vtype     string             // type of the value: string, int, float, bool, bytes or list
+	list      []string           // elements of a list, the value is empty
+	reserved  []reserved_message // messages of a list taken by "reserve", until "ack"
 }
 
-var maxdata uint64 = 10000 // max data number
-var data_index uint64 = 0  // for loading multiple databases into one big database
-var free_index uint64 = 0  // next index of free data entry
+var maxdata uint64 = 0 // max number of keys in a database, 0 = no limit
 var server_port string = "2000"
 var server_http_port string = ""
 var server_host = "localhost"
-var server net.Listener
-var pdata *[]data
 var tls_flag string = ""
 var tls_sock bool = false // set to true if TLS/SSL socket used
 
@@ -104,9 +169,25 @@ var ip_whitelist_ind uint64 = 0
 // after 3 failed logins put IP into blacklist for banning
 var blacklist_ip []string
 var blacklist_ip_ind uint64 = 0
+var bmutex sync.RWMutex // blacklist mutex
+
+// client connections
+var max_connections uint64 = 100 // max number of clients at the same time, 0 = no limit
+var connections uint64 = 0       // number of connected clients
+var cmutex sync.Mutex            // connections mutex
+var client_connections = make(map[net.Conn]bool)
+var clients_wg sync.WaitGroup // running clients
+
+// shutdown
+var shutdown_timeout uint64 = 10 // seconds to wait for the clients
+var shutdown_save string = ""    // save the default database into this file on shutdown, if set
+
+// command line reader
+var max_line_len uint64 = 1048576 // max length of one command line in bytes
+var err_line_too_long = errors.New("line too long")
 
-var dmutex sync.Mutex      // data mutex
-var server_run bool = true // set to false by "exit" command
+var shutdown_chan = make(chan bool) // closed by "exit" command to stop the server
+var shutdown_once sync.Once
 
 func print_message(logtext string) {
 	t := time.Now()
@@ -150,8 +231,6 @@ func check_whitelist(ip string) bool {
 }
 
 func run_server() {
-	var client_ip string
-
 	print_message("run_server...")
 	if server_http_port != "off" {
 		go handle_http_request()
@@ -162,35 +241,10 @@ func run_server() {
 		os.Exit(1)
 	}
 	defer server.Close()
-	print_message("Listening on " + server_host + ":" + server_port)
-	print_message("Waiting for client...")
-	for {
-		connection, err := server.Accept()
-		defer connection.Close()
-		if err != nil {
-			print_message("Error accepting:" + err.Error())
-			os.Exit(1)
-		}
-		client_ip = get_client_ip(connection.RemoteAddr().String())
-		if check_whitelist(client_ip) {
-			if check_blacklist(client_ip) {
-				print_message("Error: IP:" + client_ip + "is blacklisted! Connection blocked!")
-			} else {
-				print_message("client connected: " + client_ip)
-				if process_client(connection) == 1 {
-					// shutdown
-					return
-				}
-			}
-		} else {
-			print_message("access denied!" + client_ip)
-		}
-	}
+	accept_clients(server)
 }
 
 func run_server_tls() {
-	var client_ip string
-
 	print_message("run_server...")
 	if server_http_port != "off" {
 		go handle_http_request()
@@ -213,38 +267,279 @@ func run_server_tls() {
 		os.Exit(1)
 	}
 	defer server.Close()
+	accept_clients(server)
+}
+
+// signal the server to shut down, safe to call from more than one client
+func server_shutdown() {
+	shutdown_once.Do(func() {
+		close(shutdown_chan)
+	})
+}
+
+func server_is_shutdown() bool {
+	select {
+	case <-shutdown_chan:
+		return true
+	default:
+		return false
+	}
+}
+
+// count a new client connection, return false if the connections limit is reached
+func connection_open(connection net.Conn) bool {
+	cmutex.Lock()
+	if max_connections > 0 && connections >= max_connections {
+		cmutex.Unlock()
+		return false
+	}
+	connections++
+	client_connections[connection] = true
+	clients_wg.Add(1)
+	cmutex.Unlock()
+	return true
+}
+
+func connection_close(connection net.Conn) {
+	cmutex.Lock()
+	connections--
+	delete(client_connections, connection)
+	clients_wg.Done()
+	cmutex.Unlock()
+}
+
+// stop all clients after their running command, and wait until they are closed
+// returns false on timeout
+func stop_clients(timeout time.Duration) bool {
+	var done = make(chan bool)
+
+	cmutex.Lock()
+	for connection := range client_connections {
+		// a waiting read returns at once, a running command is finished first
+		connection.SetReadDeadline(time.Now())
+	}
+	cmutex.Unlock()
+
+	go func() {
+		clients_wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// called after the server loop ended: by signal or "exit" command
+func shutdown() {
+	print_message("shutdown: waiting for clients...")
+	if !stop_clients(time.Duration(shutdown_timeout) * time.Second) {
+		print_message("shutdown: timeout, clients still running!")
+	}
+	shutdown_http()
+
+	if shutdown_save != "" {
+		// the default database into the shutdown save file, the other ones into their save files
+		for _, db := range get_databases() {
+			file_path := get_database_file(db)
+			if db.name == DEFAULT_DATABASE {
+				file_path = database_root + shutdown_save
+			}
+			if save_data(db, file_path) != 0 {
+				print_message("shutdown: Error saving " + file_path)
+			}
+		}
+	}
+	close_log()
+	print_message("shutdown: done")
+}
+
+// shutdown on SIGINT and SIGTERM
+func handle_signals() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		print_message("got signal: " + sig.String())
+		server_shutdown()
+	}()
+}
+
+// accept loop for normal and TLS sockets, every client runs in its own goroutine
+func accept_clients(server net.Listener) {
+	var client_ip string
+
+	// close the listener on shutdown, so that Accept returns
+	go func() {
+		<-shutdown_chan
+		server.Close()
+	}()
+
 	print_message("Listening on " + server_host + ":" + server_port)
 	print_message("Waiting for client...")
 	for {
 		connection, err := server.Accept()
-		defer connection.Close()
 		if err != nil {
+			if server_is_shutdown() {
+				print_message("server shutdown!")
+				return
+			}
 			print_message("Error accepting:" + err.Error())
 			os.Exit(1)
 		}
 		client_ip = get_client_ip(connection.RemoteAddr().String())
-		if check_whitelist(client_ip) {
-			if check_blacklist(client_ip) {
-				print_message("Error: IP:" + client_ip + "is blacklisted! Connection blocked!")
+		if !check_whitelist(client_ip) {
+			print_message("access denied!" + client_ip)
+			connection.Close()
+			continue
+		}
+		if check_blacklist(client_ip) {
+			print_message("Error: IP:" + client_ip + "is blacklisted! Connection blocked!")
+			connection.Close()
+			continue
+		}
+		if !connection_open(connection) {
+			print_message("server busy! connection refused: " + client_ip)
+			_, err = connection.Write([]byte("ERROR server busy!\n"))
+			if err != nil {
+				print_message("accept_clients: Error writing:" + err.Error())
+			}
+			connection.Close()
+			continue
+		}
+		print_message("client connected: " + client_ip)
+		go handle_client(connection)
+	}
+}
+
+func handle_client(connection net.Conn) {
+	if process_client(connection) == 1 {
+		// exit command, shutdown
+		server_shutdown()
+	}
+	connection_close(connection)
+}
+
+// send a list of keys and values: first the number of entries, then one entry per line
+func send_data_list(connection net.Conn, keys []string, values []string) {
+	var i int
+	var list strings.Builder
+
+	list.WriteString(strconv.Itoa(len(keys)) + "\n")
+	for i = 0; i < len(keys); i++ {
+		list.WriteString(":" + keys[i] + " '" + values[i] + "'\n")
+	}
+	_, err := connection.Write([]byte(list.String()))
+	if err != nil {
+		print_message("send_data_list: Error writing:" + err.Error())
+	}
+}
+
+// send the result of a conditional store: "OK" if stored, "FAILED" if the condition is false
+func send_store_result(connection net.Conn, ret_err int) {
+	var reply string
+
+	switch ret_err {
+	case STORE_OK:
+		reply = "OK\n"
+	case STORE_FAILED:
+		reply = "FAILED\n"
+	default:
+		reply = "ERROR\n"
+	}
+	_, err := connection.Write([]byte(reply))
+	if err != nil {
+		print_message("send_store_result: Error writing:" + err.Error())
+	}
+}
+
+// send the new value of a counter, or the error
+func send_counter(connection net.Conn, ret_err int, value string) {
+	var reply string
+
+	switch ret_err {
+	case COUNTER_OK:
+		reply = value + "\n"
+	case COUNTER_NOT_NUMBER:
+		reply = "ERROR value is not a number!\n"
+	default:
+		reply = "ERROR\n"
+	}
+	_, err := connection.Write([]byte(reply))
+	if err != nil {
+		print_message("send_counter: Error writing:" + err.Error())
+	}
+}
+
+// send the reply of a list command, or the error
+func send_list(connection net.Conn, ret_err int, value string) {
+	var reply string
+
+	switch ret_err {
+	case LIST_OK:
+		reply = value + "\n"
+	case LIST_NOT_LIST:
+		reply = "ERROR value is not a list!\n"
+	case LIST_TIMEOUT:
+		reply = "TIMEOUT\n"
+	case LIST_EMPTY:
+		reply = "EMPTY\n"
+	default:
+		reply = "ERROR\n"
+	}
+	_, err := connection.Write([]byte(reply))
+	if err != nil {
+		print_message("send_list: Error writing:" + err.Error())
+	}
+}
+
+// read one command line from the client, without the line end.
+// More than one command can be sent at once, each one on its own line.
+func read_line(reader *bufio.Reader) (string, error) {
+	var line []byte
+	var too_long bool = false
+
+	for {
+		part, err := reader.ReadSlice('\n')
+		if !too_long {
+			// the line end is not counted in the line length
+			if uint64(len(line)+len(bytes.TrimRight(part, "\r\n"))) > max_line_len {
+				too_long = true
+				line = nil
 			} else {
-				print_message("client connected: " + client_ip)
-				if process_client(connection) == 1 {
-					// shutdown
-					return
-				}
+				line = append(line, part...)
 			}
-		} else {
-			print_message("access denied!" + client_ip)
 		}
+		if err == bufio.ErrBufferFull {
+			// line is longer than the read buffer, read the rest
+			continue
+		}
+		if err != nil {
+			if err == io.EOF && len(line) > 0 {
+				// last line without line end
+				break
+			}
+			return "", err
+		}
+		break
+	}
+
+	if too_long {
+		return "", err_line_too_long
 	}
+	return strings.TrimRight(string(line), "\r\n"), nil
 }
 
 func process_client(connection net.Conn) int {
 	var run_loop bool = true
-	buffer := make([]byte, 4096)
+	reader := bufio.NewReader(connection)
 	var key string = ""
 	var value string = ""
 	var used_space uint64 = 0
+	var data_size uint64 = 0
 	var used_space_percent float64 = 0.0
 	var info string = ""
 	var ret uint64
@@ -253,6 +548,45 @@ func process_client(connection net.Conn) int {
 
 	var match bool
 	var inputstr string = ""
+	var err error
+	var ret_err int = 0
+	var limit uint64 = 0
+	var offset uint64 = 0
+	var keys []string
+	var values []string
+	var cursor uint64 = 0
+	var pattern string = ""
+	var db_name string = DEFAULT_DATABASE // database of this connection
+	var db *database
+	var db_names []string
+	var db_used []uint64
+	var seconds uint64 = 0
+	var used_memory uint64 = 0
+	var evicted uint64 = 0
+	var number int64 = 0
+	var number_float float64 = 0.0
+	var expected string = ""
+	var condition int = 0
+	var ttl int64 = 0
+	var created int64 = 0
+	var updated int64 = 0
+	var version uint64 = 0
+	var history []history_entry
+	var ms int64 = 0
+	var vtype string = ""
+	var operator string = ""
+	var compare float64 = 0
+	var length int = 0
+	var start int64 = 0
+	var stop int64 = 0
+	var elements []string
+	var id uint64 = 0
+	var ready int = 0
+	var reserved int = 0
+	var counter queue_counter
+	var tr transaction // transaction of this connection
+	var replies []string
+	var failed int = 0
 
 	var tls_auth bool = false // set to true if user password matches l1vmgodata password
 	var user_role string = "normal-user"
@@ -263,19 +597,24 @@ func process_client(connection net.Conn) int {
 	var client_ip string
 
 	for run_loop {
-		mLen, err := connection.Read(buffer)
+		// read one command line
+		inputstr, err = read_line(reader)
+		if err == err_line_too_long {
+			_, err = connection.Write([]byte("ERROR line too long!\n"))
+			if err != nil {
+				print_message("process_client: Error writing:" + err.Error())
+			}
+			continue
+		}
 		if err != nil {
 			print_message("process_client: Error reading:" + err.Error())
 			// end for loop
 			run_loop = false
 			continue
 		}
-		// fmt.Println("Received: '", string(buffer[:mLen]), "'")
-		// fmt.Println("length: ", mLen)
-		// fmt.Println(strings.HasPrefix(str, "the")) // false
-		// check close
+		// fmt.Println("Received: '", inputstr, "'")
 
-		inputstr = string(buffer)
+		// check close
 
 		match = strings.HasPrefix(inputstr, CLOSE_CONNECTION)
 		if match {
@@ -295,7 +634,7 @@ func process_client(connection net.Conn) int {
 
 				// cleanup
 				print_message("cleaning up and exit!")
-				//init_data()
+				//init_databases()
 				// server.Close()
 				// pdata = nil
 
@@ -315,7 +654,7 @@ func process_client(connection net.Conn) int {
 		if match {
 			print_message("got login... ")
 
-			key = split_key(string(buffer[:mLen]))
+			key = split_key(inputstr)
 			if key == "" {
 				_, err = connection.Write([]byte("ERROR\n"))
 				if err != nil {
@@ -323,7 +662,7 @@ func process_client(connection net.Conn) int {
 				}
 			}
 
-			value = split_value(string(buffer[:mLen]))
+			value = split_value(inputstr)
 			if value == "" {
 				_, err = connection.Write([]byte("ERROR\n"))
 				if err != nil {
@@ -372,8 +711,187 @@ func process_client(connection net.Conn) int {
 			}
 		}
 
-		// store new data, don't check if key already used
-		// extreme speedup over store data!!!
+		// start a transaction, the next commands are queued until "commit"
+		match = strings.HasPrefix(inputstr, BEGIN_TRANSACTION)
+		if match {
+			if tr.active {
+				_, err = connection.Write([]byte("ERROR transaction already started!\n"))
+			} else {
+				tr.active = true
+				_, err = connection.Write([]byte("OK\n"))
+			}
+			if err != nil {
+				print_message("process_client: Error writing:" + err.Error())
+			}
+			continue
+		}
+
+		// run the queued commands at once, send the number of replies and the reply of every command
+		match = strings.HasPrefix(inputstr, COMMIT_TRANSACTION)
+		if match {
+			if !tr.active {
+				_, err = connection.Write([]byte("ERROR no transaction started!\n"))
+				if err != nil {
+					print_message("process_client: Error writing:" + err.Error())
+				}
+				continue
+			}
+			ret_err, replies, failed = commit_transaction(&tr, get_database(db_name))
+			switch ret_err {
+			case TRANSACTION_OK:
+				info = strconv.Itoa(len(replies)) + "\n"
+				for _, reply := range replies {
+					info = info + reply + "\n"
+				}
+			case TRANSACTION_ABORTED:
+				info = "ABORTED\n"
+			default:
+				info = "ERROR command " + strconv.Itoa(failed) + " failed!\n"
+			}
+			_, err = connection.Write([]byte(info))
+			if err != nil {
+				print_message("process_client: Error writing:" + err.Error())
+			}
+			continue
+		}
+
+		// remove the queued commands and the watched keys
+		match = strings.HasPrefix(inputstr, DISCARD_TRANSACTION)
+		if match {
+			if !tr.active {
+				_, err = connection.Write([]byte("ERROR no transaction started!\n"))
+			} else {
+				tr = transaction{}
+				_, err = connection.Write([]byte("OK\n"))
+			}
+			if err != nil {
+				print_message("process_client: Error writing:" + err.Error())
+			}
+			continue
+		}
+
+		// queue a command of the transaction
+		if tr.active {
+			switch check_transaction_command(inputstr, user_role) {
+			case 0:
+				tr.commands = append(tr.commands, inputstr)
+				_, err = connection.Write([]byte("QUEUED\n"))
+			case 2:
+				_, err = connection.Write([]byte("ERROR\n"))
+			default:
+				_, err = connection.Write([]byte("ERROR command not allowed in a transaction!\n"))
+			}
+			if err != nil {
+				print_message("process_client: Error writing:" + err.Error())
+			}
+			continue
+		}
+
+		// create a new empty database
+		match = strings.HasPrefix(inputstr, CREATE_DATABASE)
+		if match {
+			key = split_key(inputstr)
+			if user_role == "read-only" || key == "" || create_database(key) != 0 {
+				_, err = connection.Write([]byte("ERROR\n"))
+				if err != nil {
+					print_message("process_client: Error writing:" + err.Error())
+				}
+			} else {
+				_, err = connection.Write([]byte("OK\n"))
+				if err != nil {
+					print_message("process_client: Error writing:" + err.Error())
+				}
+			}
+			continue
+		}
+
+		// use a database for the next commands of this connection
+		match = strings.HasPrefix(inputstr, USE_DATABASE)
+		if match {
+			key = split_key(inputstr)
+			if key == "" || get_database(key) == nil {
+				_, err = connection.Write([]byte("ERROR\n"))
+				if err != nil {
+					print_message("process_client: Error writing:" + err.Error())
+				}
+			} else {
+				db_name = key
+				_, err = connection.Write([]byte("OK\n"))
+				if err != nil {
+					print_message("process_client: Error writing:" + err.Error())
+				}
+			}
+			continue
+		}
+
+		// remove a database and all of its data
+		match = strings.HasPrefix(inputstr, DROP_DATABASE)
+		if match {
+			if user_role != "admin" {
+				_, err = connection.Write([]byte("ERROR not admin user! Dropping denied!\n"))
+				if err != nil {
+					print_message("process_client: Error writing:" + err.Error())
+				}
+				continue
+			}
+			key = split_key(inputstr)
+			if key == "" || drop_database(key) != 0 {
+				_, err = connection.Write([]byte("ERROR\n"))
+				if err != nil {
+					print_message("process_client: Error writing:" + err.Error())
+				}
+			} else {
+				_, err = connection.Write([]byte("OK\n"))
+				if err != nil {
+					print_message("process_client: Error writing:" + err.Error())
+				}
+			}
+			continue
+		}
+
+		// list the names of all databases and their number of keys
+		match = strings.HasPrefix(inputstr, LIST_DATABASES)
+		if match {
+			db_names, db_used = get_database_list()
+			info = strconv.Itoa(len(db_names)) + "\n"
+			for i := range db_names {
+				info = info + ":" + db_names[i] + " '" + strconv.FormatUint(db_used[i], 10) + "'\n"
+			}
+			_, err = connection.Write([]byte(info))
+			if err != nil {
+				print_message("process_client: Error writing:" + err.Error())
+			}
+			continue
+		}
+
+		// the database of this connection can be dropped by another client
+		db = get_database(db_name)
+		if db == nil {
+			db_name = DEFAULT_DATABASE
+			_, err = connection.Write([]byte("ERROR database dropped! Using database default.\n"))
+			if err != nil {
+				print_message("process_client: Error writing:" + err.Error())
+			}
+			continue
+		}
+
+		// watch a key for the next transaction, the commit is aborted if the key is changed
+		match = strings.HasPrefix(inputstr, WATCH_KEY)
+		if match {
+			key = split_key(inputstr)
+			if key == "" || watch_key(&tr, db, key) != 0 {
+				_, err = connection.Write([]byte("ERROR\n"))
+			} else {
+				_, err = connection.Write([]byte("OK\n"))
+			}
+			if err != nil {
+				print_message("process_client: Error writing:" + err.Error())
+			}
+			continue
+		}
+
+		// store new data, for old programs: the same as store data.
+		// The key index finds an already used key, so a key is not stored twice
 		match = strings.HasPrefix(inputstr, STORE_DATA_NEW)
 		if match {
 			if user_role == "read-only" {
@@ -386,85 +904,759 @@ func process_client(connection net.Conn) int {
 
 			// store key/value pair
 			// try to store data
-			if check_data(string(buffer[:mLen])) != 0 {
+			if check_data(inputstr) != 0 {
 				_, err = connection.Write([]byte("ERROR\n"))
 				if err != nil {
 					print_message("process_client: Error writing:" + err.Error())
 				}
 				continue
 			}
-			key, value = split_data(string(buffer[:mLen]))
+			key, value = split_data(inputstr)
 			if key != "" {
-				if store_data_new(key, value) == 0 {
+				if store_data_new(db, key, value) == 0 {
 					_, err = connection.Write([]byte("OK\n"))
 					if err != nil {
 						print_message("process_client: Error writing:" + err.Error())
 					}
 				} else {
-					_, err = connection.Write([]byte("ERROR\n"))
-					if err != nil {
-						print_message("process_client: Error writing:" + err.Error())
-					}
+					_, err = connection.Write([]byte("ERROR\n"))
+					if err != nil {
+						print_message("process_client: Error writing:" + err.Error())
+					}
+				}
+			} else {
+				_, err = connection.Write([]byte("ERROR\n"))
+				if err != nil {
+					print_message("process_client: Error writing:" + err.Error())
+				}
+			}
+			continue
+		}
+
+		// store data only if the key is not set, or only if it is set
+		match = strings.HasPrefix(inputstr, STORE_DATA_IF_ABSENT) || strings.HasPrefix(inputstr, STORE_DATA_IF_PRESENT)
+		if match {
+			condition = STORE_IF_ABSENT
+			if strings.HasPrefix(inputstr, STORE_DATA_IF_PRESENT) {
+				condition = STORE_IF_PRESENT
+			}
+			ret_err = STORE_ERROR
+			if user_role != "read-only" && check_data(inputstr) == 0 {
+				key, value = split_data(inputstr)
+				if key != "" {
+					ret_err = store_data_if(db, key, value, condition, "")
+				}
+			}
+			send_store_result(connection, ret_err)
+			continue
+		}
+
+		// store data only if the key has the expected version
+		match = strings.HasPrefix(inputstr, STORE_DATA_IF_VERSION)
+		if match {
+			ret_err, expected = split_version(inputstr)
+			if ret_err == 0 && user_role != "read-only" && check_data(inputstr) == 0 {
+				ret_err = STORE_ERROR
+				key, value = split_data(inputstr)
+				if key != "" {
+					ret_err = store_data_if(db, key, value, STORE_IF_VERSION, expected)
+				}
+			} else {
+				ret_err = STORE_ERROR
+			}
+			send_store_result(connection, ret_err)
+			continue
+		}
+
+		// get the created and updated time and the version of a key
+		match = strings.HasPrefix(inputstr, GET_META)
+		if match {
+			key = split_key(inputstr)
+			ret_err = 1
+			if key != "" {
+				ret_err, created, updated, version = get_meta(db, key)
+			}
+			if ret_err != 0 {
+				info = "ERROR\n"
+			} else {
+				info = format_meta(created, updated, version) + "\n"
+			}
+			_, err = connection.Write([]byte(info))
+			if err != nil {
+				print_message("process_client: Error writing:" + err.Error())
+			}
+			continue
+		}
+
+		// set the history depth of the database, or get it without a number
+		match = strings.HasPrefix(inputstr, HISTORY_DEPTH)
+		if match {
+			value = split_value(inputstr)
+			if value == "" {
+				info = strconv.Itoa(get_history_depth(db)) + "\n"
+			} else {
+				number, err = strconv.ParseInt(value, 10, 64)
+				if user_role == "read-only" || err != nil || number > HISTORY_MAX_DEPTH || set_history_depth(db, int(number)) != 0 {
+					info = "ERROR\n"
+				} else {
+					info = "OK\n"
+				}
+			}
+			_, err = connection.Write([]byte(info))
+			if err != nil {
+				print_message("process_client: Error writing:" + err.Error())
+			}
+			continue
+		}
+
+		// get the values of a key, the current value first: <version> <updated> 'value'
+		match = strings.HasPrefix(inputstr, GET_HISTORY)
+		if match {
+			key = split_key(inputstr)
+			ret_err = 1
+			if key != "" {
+				ret_err, history = get_history(db, key)
+			}
+			if ret_err != 0 {
+				info = "ERROR\n"
+			} else {
+				info = strconv.Itoa(len(history)) + "\n"
+				for _, entry := range history {
+					info = info + strconv.FormatUint(entry.version, 10) + " " + strconv.FormatInt(entry.updated, 10) + " '" + strings.Trim(entry.value, "'\n") + "'\n"
+				}
+			}
+			_, err = connection.Write([]byte(info))
+			if err != nil {
+				print_message("process_client: Error writing:" + err.Error())
+			}
+			continue
+		}
+
+		// get the value of a key at a time in unix milliseconds
+		match = strings.HasPrefix(inputstr, GET_DATA_KEY_AT)
+		if match {
+			key = split_key(inputstr)
+			ret_err, ms = split_time(inputstr)
+			if ret_err == 0 && key != "" {
+				ret_err, value = get_data_key_at(db, key, ms)
+			} else {
+				ret_err = 1
+			}
+			if ret_err != 0 {
+				info = "ERROR\n"
+			} else {
+				info = value + "\n"
+			}
+			_, err = connection.Write([]byte(info))
+			if err != nil {
+				print_message("process_client: Error writing:" + err.Error())
+			}
+			continue
+		}
+
+		// store the value of an old version of a key as a new version
+		match = strings.HasPrefix(inputstr, REVERT_DATA)
+		if match {
+			key = split_key(inputstr)
+			version, err = strconv.ParseUint(split_value(inputstr), 10, 64)
+			if user_role == "read-only" || key == "" || err != nil || revert_data(db, key, version) != 0 {
+				info = "ERROR\n"
+			} else {
+				info = "OK\n"
+			}
+			_, err = connection.Write([]byte(info))
+			if err != nil {
+				print_message("process_client: Error writing:" + err.Error())
+			}
+			continue
+		}
+
+		// compare and swap: store the new value only if the key has the expected value
+		match = strings.HasPrefix(inputstr, CAS_DATA)
+		if match {
+			key = split_key(inputstr)
+			ret_err, expected, value = split_cas_values(inputstr)
+			if ret_err == 0 {
+				ret_err = STORE_ERROR
+				if user_role != "read-only" && key != "" {
+					ret_err = store_data_if(db, key, value, STORE_IF_VALUE, expected)
+				}
+			} else {
+				ret_err = STORE_ERROR
+			}
+			send_store_result(connection, ret_err)
+			continue
+		}
+
+		// store data with a type: int, float, bool, bytes or string
+		match = strings.HasPrefix(inputstr, STORE_DATA_TYPE)
+		if match {
+			ret_err, vtype = split_type(inputstr)
+			if ret_err == 0 && user_role != "read-only" && check_data(inputstr) == 0 {
+				key, value = split_data(inputstr)
+				if key == "" || store_data_type(db, key, value, vtype) != 0 {
+					ret_err = 1
+				}
+			} else {
+				ret_err = 1
+			}
+			if ret_err != 0 {
+				info = "ERROR\n"
+			} else {
+				info = "OK\n"
+			}
+			_, err = connection.Write([]byte(info))
+			if err != nil {
+				print_message("process_client: Error writing:" + err.Error())
+			}
+			continue
+		}
+
+		// get the type of a key
+		match = strings.HasPrefix(inputstr, GET_TYPE)
+		if match {
+			key = split_key(inputstr)
+			ret_err = 1
+			if key != "" {
+				ret_err, vtype = get_type(db, key)
+			}
+			if ret_err != 0 {
+				info = "ERROR\n"
+			} else {
+				info = vtype + "\n"
+			}
+			_, err = connection.Write([]byte(info))
+			if err != nil {
+				print_message("process_client: Error writing:" + err.Error())
+			}
+			continue
+		}
+
+		// store data with a time to live in seconds
+		match = strings.HasPrefix(inputstr, STORE_DATA_TTL)
+		if match {
+			if user_role == "read-only" {
+				_, err = connection.Write([]byte("ERROR\n"))
+				if err != nil {
+					print_message("process_client: Error writing:" + err.Error())
+				}
+				continue
+			}
+
+			ret_err, seconds = split_ttl(inputstr)
+			if ret_err != 0 || check_data(inputstr) != 0 {
+				_, err = connection.Write([]byte("ERROR\n"))
+				if err != nil {
+					print_message("process_client: Error writing:" + err.Error())
+				}
+				continue
+			}
+			key, value = split_data(inputstr)
+			if key != "" && store_data_ttl(db, key, value, seconds) == 0 {
+				_, err = connection.Write([]byte("OK\n"))
+				if err != nil {
+					print_message("process_client: Error writing:" + err.Error())
+				}
+			} else {
+				_, err = connection.Write([]byte("ERROR\n"))
+				if err != nil {
+					print_message("process_client: Error writing:" + err.Error())
+				}
+			}
+			continue
+		}
+
+		// store data
+		match = strings.HasPrefix(inputstr, STORE_DATA)
+		if match {
+			if user_role == "read-only" {
+				_, err = connection.Write([]byte("ERROR\n"))
+				if err != nil {
+					print_message("process_client: Error writing:" + err.Error())
+				}
+				continue
+			}
+
+			// store key/value pair
+			// try to store data
+			if check_data(inputstr) != 0 {
+				_, err = connection.Write([]byte("ERROR\n"))
+				if err != nil {
+					print_message("process_client: Error writing:" + err.Error())
+				}
+				continue
+			}
+			key, value = split_data(inputstr)
+			if key != "" {
+				if store_data(db, key, value) == 0 {
+					_, err = connection.Write([]byte("OK\n"))
+					if err != nil {
+						print_message("process_client: Error writing:" + err.Error())
+					}
+				} else {
+					_, err = connection.Write([]byte("ERROR\n"))
+					if err != nil {
+						print_message("process_client: Error writing:" + err.Error())
+					}
+				}
+			} else {
+				_, err = connection.Write([]byte("ERROR\n"))
+				if err != nil {
+					print_message("process_client: Error writing:" + err.Error())
+				}
+			}
+			continue
+		}
+
+		// get data key
+		match = strings.HasPrefix(inputstr, GET_DATA_KEY)
+		if match {
+			// try to find matching key
+
+			key = split_key(inputstr)
+			if key != "" {
+				value = get_data_key(db, key)
+				if value != "" {
+					_, err = connection.Write([]byte(value))
+					if err != nil {
+						print_message("process_client: Error writing:" + err.Error())
+					}
+					_, err = connection.Write([]byte("\n"))
+					if err != nil {
+						print_message("process_client: Error writing:" + err.Error())
+					}
+				} else {
+					_, err = connection.Write([]byte("ERROR\n"))
+					if err != nil {
+						print_message("process_client: Error writing:" + err.Error())
+					}
+				}
+			} else {
+				_, err = connection.Write([]byte("ERROR\n"))
+				if err != nil {
+					print_message("process_client: Error writing:" + err.Error())
+				}
+			}
+			continue
+		}
+
+		// get data of first key containing the search string
+		match = strings.HasPrefix(inputstr, GET_DATA_KEY_CONTAINS)
+		if match {
+			// try to find matching key
+
+			key = split_key(inputstr)
+			if key != "" {
+				value = get_data_key_contains(db, key)
+				if value != "" {
+					_, err = connection.Write([]byte(value))
+					if err != nil {
+						print_message("process_client: Error writing:" + err.Error())
+					}
+					_, err = connection.Write([]byte("\n"))
+					if err != nil {
+						print_message("process_client: Error writing:" + err.Error())
+					}
+				} else {
+					_, err = connection.Write([]byte("ERROR\n"))
+					if err != nil {
+						print_message("process_client: Error writing:" + err.Error())
+					}
+				}
+			} else {
+				_, err = connection.Write([]byte("ERROR\n"))
+				if err != nil {
+					print_message("process_client: Error writing:" + err.Error())
+				}
+			}
+			continue
+		}
+
+		// get data of first key starting with the search string
+		match = strings.HasPrefix(inputstr, GET_DATA_KEY_PREFIX)
+		if match {
+			// try to find matching key
+
+			key = split_key(inputstr)
+			if key != "" {
+				value = get_data_key_prefix(db, key)
+				if value != "" {
+					_, err = connection.Write([]byte(value))
+					if err != nil {
+						print_message("process_client: Error writing:" + err.Error())
+					}
+					_, err = connection.Write([]byte("\n"))
+					if err != nil {
+						print_message("process_client: Error writing:" + err.Error())
+					}
+				} else {
+					_, err = connection.Write([]byte("ERROR\n"))
+					if err != nil {
+						print_message("process_client: Error writing:" + err.Error())
+					}
+				}
+			} else {
+				_, err = connection.Write([]byte("ERROR\n"))
+				if err != nil {
+					print_message("process_client: Error writing:" + err.Error())
+				}
+			}
+			continue
+		}
+
+		// get data value
+		match = strings.HasPrefix(inputstr, GET_DATA_VALUE)
+		if match {
+			// try to find matching value
+			value = split_value(inputstr)
+			if value != "" {
+				key = get_data_value(db, value)
+				if key != "" {
+					_, err = connection.Write([]byte(key))
+					if err != nil {
+						print_message("process_client: Error writing:" + err.Error())
+					}
+					_, err = connection.Write([]byte("\n"))
+					if err != nil {
+						print_message("process_client: Error writing:" + err.Error())
+					}
+				} else {
+					_, err = connection.Write([]byte("ERROR\n"))
+					if err != nil {
+						print_message("process_client: Error writing:" + err.Error())
+					}
+				}
+			} else {
+				_, err = connection.Write([]byte("ERROR\n"))
+				if err != nil {
+					print_message("process_client: Error writing:" + err.Error())
+				}
+			}
+			continue
+		}
+
+		// remove data, send value
+		match = strings.HasPrefix(inputstr, REMOVE_DATA)
+		if match {
+			if user_role == "read-only" {
+				_, err = connection.Write([]byte("ERROR\n"))
+				if err != nil {
+					print_message("process_client: Error writing:" + err.Error())
+				}
+				continue
+			}
+
+			// try to find matching key
+			key = split_key(inputstr)
+			if key != "" {
+				value = remove_data(db, key)
+				if value != "" {
+					_, err = connection.Write([]byte(value))
+					if err != nil {
+						print_message("process_client: Error writing:" + err.Error())
+					}
+					_, err = connection.Write([]byte("\n"))
+					if err != nil {
+						print_message("process_client: Error writing:" + err.Error())
+					}
+				} else {
+					_, err = connection.Write([]byte("ERROR\n"))
+					if err != nil {
+						print_message("process_client: Error writing:" + err.Error())
+					}
+				}
+			} else {
+				_, err = connection.Write([]byte("ERROR\n"))
+				if err != nil {
+					print_message("process_client: Error writing:" + err.Error())
+				}
+			}
+			continue
+		}
+
+		// set the time to live of a key in seconds
+		match = strings.HasPrefix(inputstr, EXPIRE_DATA)
+		if match {
+			key = split_key(inputstr)
+			ret_err, seconds = split_seconds(split_value(inputstr))
+			if user_role == "read-only" || key == "" || ret_err != 0 || expire_data(db, key, seconds) != 0 {
+				_, err = connection.Write([]byte("ERROR\n"))
+				if err != nil {
+					print_message("process_client: Error writing:" + err.Error())
+				}
+			} else {
+				_, err = connection.Write([]byte("OK\n"))
+				if err != nil {
+					print_message("process_client: Error writing:" + err.Error())
+				}
+			}
+			continue
+		}
+
+		// get the seconds until a key expires, -1 if it doesn't expire
+		match = strings.HasPrefix(inputstr, GET_TTL)
+		if match {
+			key = split_key(inputstr)
+			ret_err = 1
+			if key != "" {
+				ret_err, ttl = get_ttl(db, key)
+			}
+			if ret_err != 0 {
+				_, err = connection.Write([]byte("ERROR\n"))
+				if err != nil {
+					print_message("process_client: Error writing:" + err.Error())
+				}
+				continue
+			}
+			info = strconv.FormatInt(ttl, 10) + "\n"
+			_, err = connection.Write([]byte(info))
+			if err != nil {
+				print_message("process_client: Error writing:" + err.Error())
+			}
+			continue
+		}
+
+		// remove the time to live of a key
+		match = strings.HasPrefix(inputstr, PERSIST_DATA)
+		if match {
+			key = split_key(inputstr)
+			if user_role == "read-only" || key == "" || persist_data(db, key) != 0 {
+				_, err = connection.Write([]byte("ERROR\n"))
+				if err != nil {
+					print_message("process_client: Error writing:" + err.Error())
+				}
+			} else {
+				_, err = connection.Write([]byte("OK\n"))
+				if err != nil {
+					print_message("process_client: Error writing:" + err.Error())
+				}
+			}
+			continue
+		}
+
+		// add a floating point number to the value of a key, send the new value
+		match = strings.HasPrefix(inputstr, INCR_FLOAT_DATA)
+		if match {
+			key = split_key(inputstr)
+			ret_err, number_float = split_number_float(inputstr)
+			if ret_err != 0 {
+				key = ""
+			}
+			ret_err = COUNTER_ERROR
+			if user_role != "read-only" && key != "" {
+				ret_err, value = incr_data_float(db, key, number_float)
+			}
+			send_counter(connection, ret_err, value)
+			continue
+		}
+
+		// add a number to the value of a key, send the new value
+		match = strings.HasPrefix(inputstr, INCR_DATA) || strings.HasPrefix(inputstr, DECR_DATA)
+		if match {
+			key = split_key(inputstr)
+			ret_err, number = split_number(inputstr)
+			if ret_err != 0 {
+				key = ""
+			}
+			ret_err = COUNTER_ERROR
+			if strings.HasPrefix(inputstr, DECR_DATA) {
+				number = -number
+			}
+			if user_role != "read-only" && key != "" {
+				ret_err, value = incr_data(db, key, number)
+			}
+			send_counter(connection, ret_err, value)
+			continue
+		}
+
+		// add an element at the head or the tail of a list, send the new length
+		match = strings.HasPrefix(inputstr, LIST_LPUSH) || strings.HasPrefix(inputstr, LIST_RPUSH)
+		if match {
+			ret_err = LIST_ERROR
+			if user_role != "read-only" && check_data(inputstr) == 0 {
+				key, value = split_data(inputstr)
+				if key != "" {
+					ret_err, length = push_list(db, key, value, strings.HasPrefix(inputstr, LIST_LPUSH))
+				}
+			}
+			send_list(connection, ret_err, strconv.Itoa(length))
+			continue
+		}
+
+		// remove the element at the head or the tail of a list, send the element
+		match = strings.HasPrefix(inputstr, LIST_LPOP) || strings.HasPrefix(inputstr, LIST_RPOP)
+		if match {
+			key = split_key(inputstr)
+			ret_err = LIST_ERROR
+			if user_role != "read-only" && key != "" {
+				ret_err, value = pop_list(db, key, strings.HasPrefix(inputstr, LIST_LPOP))
+			}
+			send_list(connection, ret_err, value)
+			continue
+		}
+
+		// remove the element at the head or the tail of a list, wait for it if the list is empty
+		match = strings.HasPrefix(inputstr, LIST_BLPOP) || strings.HasPrefix(inputstr, LIST_BRPOP)
+		if match {
+			key = split_key(inputstr)
+			ret_err, seconds = split_timeout(inputstr)
+			left := strings.HasPrefix(inputstr, LIST_BLPOP)
+			if ret_err == 0 && user_role != "read-only" && key != "" {
+				closed, stop := watch_connection(connection, reader)
+				ret_err, value = pop_list_wait(db, key, left, seconds, closed)
+				stop()
+				select {
+				case <-closed:
+					if ret_err == LIST_OK {
+						// the element was given to the client before the closed connection was seen
+						push_list(db, key, value, left)
+					}
+					run_loop = false
+					continue
+				default:
+				}
+			} else {
+				ret_err = LIST_ERROR
+			}
+			if ret_err != LIST_OK {
+				send_list(connection, ret_err, "")
+				continue
+			}
+			_, err = connection.Write([]byte(value + "\n"))
+			if err != nil {
+				print_message("process_client: Error writing:" + err.Error())
+				// the client is gone, the element is put back for the next one
+				push_list(db, key, value, left)
+			}
+			continue
+		}
+
+		// reserve the element at the head of a list for the visibility timeout in seconds: <id> 'element'
+		match = strings.HasPrefix(inputstr, QUEUE_RESERVE)
+		if match {
+			key = split_key(inputstr)
+			ret_err = 0
+			seconds = QUEUE_VISIBILITY
+			if split_value(inputstr) != "" {
+				ret_err, seconds = split_seconds(split_value(inputstr))
+			}
+			if ret_err == 0 && user_role != "read-only" && key != "" {
+				ret_err, id, value = reserve_queue(db, key, seconds)
+			} else {
+				ret_err = LIST_ERROR
+			}
+			send_list(connection, ret_err, strconv.FormatUint(id, 10)+" '"+value+"'")
+			continue
+		}
+
+		// acknowledge a reserved message, or put it back into the list
+		match = strings.HasPrefix(inputstr, QUEUE_ACK) || strings.HasPrefix(inputstr, QUEUE_NACK)
+		if match {
+			key = split_key(inputstr)
+			id, err = strconv.ParseUint(split_value(inputstr), 10, 64)
+			if err == nil && user_role != "read-only" && key != "" {
+				if strings.HasPrefix(inputstr, QUEUE_ACK) {
+					ret_err = ack_queue(db, key, id)
+				} else {
+					ret_err = nack_queue(db, key, id)
 				}
 			} else {
-				_, err = connection.Write([]byte("ERROR\n"))
-				if err != nil {
-					print_message("process_client: Error writing:" + err.Error())
-				}
+				ret_err = LIST_ERROR
 			}
+			send_list(connection, ret_err, "OK")
 			continue
 		}
 
-		// store data
-		match = strings.HasPrefix(inputstr, STORE_DATA)
+		// get the element at the head of a list, without removing it
+		match = strings.HasPrefix(inputstr, QUEUE_PEEK)
 		if match {
-			if user_role == "read-only" {
-				_, err = connection.Write([]byte("ERROR\n"))
-				if err != nil {
-					print_message("process_client: Error writing:" + err.Error())
-				}
-				continue
+			key = split_key(inputstr)
+			ret_err = LIST_ERROR
+			if key != "" {
+				ret_err, value = peek_queue(db, key)
 			}
+			send_list(connection, ret_err, value)
+			continue
+		}
 
-			// store key/value pair
-			// try to store data
-			if check_data(string(buffer[:mLen])) != 0 {
-				_, err = connection.Write([]byte("ERROR\n"))
-				if err != nil {
-					print_message("process_client: Error writing:" + err.Error())
-				}
+		// get the number of ready and reserved messages and the counters of a queue
+		match = strings.HasPrefix(inputstr, QUEUE_STATS)
+		if match {
+			key = split_key(inputstr)
+			ret_err = LIST_ERROR
+			if key != "" {
+				ret_err, ready, reserved, counter = get_queue_stats(db, key)
+			}
+			send_list(connection, ret_err, format_queue_stats(ready, reserved, counter))
+			continue
+		}
+
+		// get the elements from start to stop of a list: <number> and then 'element' lines
+		match = strings.HasPrefix(inputstr, LIST_RANGE)
+		if match {
+			key = split_key(inputstr)
+			ret_err, start, stop = split_range(inputstr)
+			if ret_err == 0 && key != "" {
+				ret_err, elements = get_list_range(db, key, start, stop)
+			} else {
+				ret_err = LIST_ERROR
+			}
+			if ret_err != LIST_OK {
+				send_list(connection, ret_err, "")
 				continue
 			}
-			key, value = split_data(string(buffer[:mLen]))
+			info = strconv.Itoa(len(elements)) + "\n"
+			for _, element := range elements {
+				info = info + "'" + element + "'\n"
+			}
+			_, err = connection.Write([]byte(info))
+			if err != nil {
+				print_message("process_client: Error writing:" + err.Error())
+			}
+			continue
+		}
+
+		// get the number of elements of a list
+		match = strings.HasPrefix(inputstr, LIST_LENGTH)
+		if match {
+			key = split_key(inputstr)
+			ret_err = LIST_ERROR
 			if key != "" {
-				if store_data(key, value) == 0 {
-					_, err = connection.Write([]byte("OK\n"))
-					if err != nil {
-						print_message("process_client: Error writing:" + err.Error())
-					}
-				} else {
-					_, err = connection.Write([]byte("ERROR\n"))
-					if err != nil {
-						print_message("process_client: Error writing:" + err.Error())
-					}
-				}
-			} else {
-				_, err = connection.Write([]byte("ERROR\n"))
+				ret_err, length = get_list_length(db, key)
+			}
+			send_list(connection, ret_err, strconv.Itoa(length))
+			continue
+		}
+
+		// remove elements with the value from a list, send the number of removed elements
+		match = strings.HasPrefix(inputstr, LIST_REMOVE)
+		if match {
+			key = split_key(inputstr)
+			ret_err, expected, value = split_cas_values(inputstr)
+			if ret_err == 0 {
+				number, err = strconv.ParseInt(expected, 10, 64)
 				if err != nil {
-					print_message("process_client: Error writing:" + err.Error())
+					ret_err = LIST_ERROR
 				}
+			} else {
+				ret_err = LIST_ERROR
+			}
+			if ret_err == 0 && user_role != "read-only" && key != "" {
+				ret_err, length = remove_list(db, key, number, value)
+			} else {
+				ret_err = LIST_ERROR
 			}
+			send_list(connection, ret_err, strconv.Itoa(length))
 			continue
 		}
 
-		// get data key
-		match = strings.HasPrefix(inputstr, GET_DATA_KEY)
+		// get key with regex expression
+		match = strings.HasPrefix(inputstr, GET_DATA_REGEXP_KEY)
 		if match {
 			// try to find matching key
-
-			key = split_key(string(buffer[:mLen]))
+			key = split_key(inputstr)
 			if key != "" {
-				value = get_data_key(key)
+				value = get_data_key_regexp(db, key)
 				if value != "" {
 					_, err = connection.Write([]byte(value))
 					if err != nil {
@@ -489,13 +1681,13 @@ func process_client(connection net.Conn) int {
 			continue
 		}
 
-		// get data value
-		match = strings.HasPrefix(inputstr, GET_DATA_VALUE)
+		// get value with regex expression
+		match = strings.HasPrefix(inputstr, GET_DATA_REGEXP_VALUE)
 		if match {
-			// try to find matching value
-			value = split_value(string(buffer[:mLen]))
+			// try to find matching key
+			value = split_value(inputstr)
 			if value != "" {
-				key = get_data_value(value)
+				key = get_data_value_regexp(db, value)
 				if key != "" {
 					_, err = connection.Write([]byte(key))
 					if err != nil {
@@ -520,103 +1712,143 @@ func process_client(connection net.Conn) int {
 			continue
 		}
 
-		// remove data, send value
-		match = strings.HasPrefix(inputstr, REMOVE_DATA)
+		// list all keys containing the search string
+		match = strings.HasPrefix(inputstr, LIST_KEY_CONTAINS)
 		if match {
-			if user_role == "read-only" {
+			key = split_key(inputstr)
+			ret_err, limit, offset = split_list_options(inputstr)
+			if key != "" && ret_err == 0 {
+				ret_err, keys, values = get_data_list_key_contains(db, key, limit, offset)
+			}
+			if key == "" || ret_err != 0 {
 				_, err = connection.Write([]byte("ERROR\n"))
 				if err != nil {
 					print_message("process_client: Error writing:" + err.Error())
 				}
 				continue
 			}
+			send_data_list(connection, keys, values)
+			continue
+		}
 
-			// try to find matching key
-			key = split_key(string(buffer[:mLen]))
-			if key != "" {
-				value = remove_data(key)
-				if value != "" {
-					_, err = connection.Write([]byte(value))
-					if err != nil {
-						print_message("process_client: Error writing:" + err.Error())
-					}
-					_, err = connection.Write([]byte("\n"))
-					if err != nil {
-						print_message("process_client: Error writing:" + err.Error())
-					}
-				} else {
-					_, err = connection.Write([]byte("ERROR\n"))
-					if err != nil {
-						print_message("process_client: Error writing:" + err.Error())
-					}
+		// list all keys starting with the search string
+		match = strings.HasPrefix(inputstr, LIST_KEY_PREFIX)
+		if match {
+			key = split_key(inputstr)
+			ret_err, limit, offset = split_list_options(inputstr)
+			if key != "" && ret_err == 0 {
+				ret_err, keys, values = get_data_list_key_prefix(db, key, limit, offset)
+			}
+			if key == "" || ret_err != 0 {
+				_, err = connection.Write([]byte("ERROR\n"))
+				if err != nil {
+					print_message("process_client: Error writing:" + err.Error())
 				}
-			} else {
+				continue
+			}
+			send_data_list(connection, keys, values)
+			continue
+		}
+
+		// list all int and float values which compare with the number: list compare '>= 10'
+		match = strings.HasPrefix(inputstr, LIST_COMPARE)
+		if match {
+			ret_err, operator, compare = split_compare(inputstr)
+			if ret_err == 0 {
+				ret_err, limit, offset = split_list_options(inputstr)
+			}
+			if ret_err == 0 {
+				ret_err, keys, values = get_data_list_compare(db, operator, compare, limit, offset)
+			}
+			if ret_err != 0 {
 				_, err = connection.Write([]byte("ERROR\n"))
 				if err != nil {
 					print_message("process_client: Error writing:" + err.Error())
 				}
+				continue
 			}
+			send_data_list(connection, keys, values)
 			continue
 		}
 
-		// get key with regex expression
-		match = strings.HasPrefix(inputstr, GET_DATA_REGEXP_KEY)
+		// list all values containing the search string
+		match = strings.HasPrefix(inputstr, LIST_VALUE)
 		if match {
-			// try to find matching key
-			key = split_key(string(buffer[:mLen]))
-			if key != "" {
-				value = get_data_key_regexp(key)
-				if value != "" {
-					_, err = connection.Write([]byte(value))
-					if err != nil {
-						print_message("process_client: Error writing:" + err.Error())
-					}
-					_, err = connection.Write([]byte("\n"))
-					if err != nil {
-						print_message("process_client: Error writing:" + err.Error())
-					}
-				} else {
-					_, err = connection.Write([]byte("ERROR\n"))
-					if err != nil {
-						print_message("process_client: Error writing:" + err.Error())
-					}
+			value = split_value(inputstr)
+			ret_err, limit, offset = split_list_options(inputstr)
+			if value != "" && ret_err == 0 {
+				ret_err, keys, values = get_data_list_value(db, value, limit, offset)
+			}
+			if value == "" || ret_err != 0 {
+				_, err = connection.Write([]byte("ERROR\n"))
+				if err != nil {
+					print_message("process_client: Error writing:" + err.Error())
 				}
-			} else {
+				continue
+			}
+			send_data_list(connection, keys, values)
+			continue
+		}
+
+		// list all keys matching the regex expression
+		match = strings.HasPrefix(inputstr, LIST_REGEXP_KEY)
+		if match {
+			key = split_key(inputstr)
+			ret_err, limit, offset = split_list_options(inputstr)
+			if key != "" && ret_err == 0 {
+				ret_err, keys, values = get_data_list_key_regexp(db, key, limit, offset)
+			}
+			if key == "" || ret_err != 0 {
 				_, err = connection.Write([]byte("ERROR\n"))
 				if err != nil {
 					print_message("process_client: Error writing:" + err.Error())
 				}
+				continue
 			}
+			send_data_list(connection, keys, values)
 			continue
 		}
 
-		// get value with regex expression
-		match = strings.HasPrefix(inputstr, GET_DATA_REGEXP_VALUE)
+		// list all values matching the regex expression
+		match = strings.HasPrefix(inputstr, LIST_REGEXP_VALUE)
 		if match {
-			// try to find matching key
-			value = split_value(string(buffer[:mLen]))
-			if value != "" {
-				key = get_data_value_regexp(value)
-				if key != "" {
-					_, err = connection.Write([]byte(key))
-					if err != nil {
-						print_message("process_client: Error writing:" + err.Error())
-					}
-					_, err = connection.Write([]byte("\n"))
-					if err != nil {
-						print_message("process_client: Error writing:" + err.Error())
-					}
-				} else {
-					_, err = connection.Write([]byte("ERROR\n"))
-					if err != nil {
-						print_message("process_client: Error writing:" + err.Error())
-					}
+			value = split_value(inputstr)
+			ret_err, limit, offset = split_list_options(inputstr)
+			if value != "" && ret_err == 0 {
+				ret_err, keys, values = get_data_list_value_regexp(db, value, limit, offset)
+			}
+			if value == "" || ret_err != 0 {
+				_, err = connection.Write([]byte("ERROR\n"))
+				if err != nil {
+					print_message("process_client: Error writing:" + err.Error())
 				}
-			} else {
+				continue
+			}
+			send_data_list(connection, keys, values)
+			continue
+		}
+
+		// scan the keys, the cursor is the data index to start from
+		match = strings.HasPrefix(inputstr, SCAN_DATA)
+		if match {
+			ret_err, cursor, pattern, limit = split_scan_options(inputstr)
+			if ret_err == 0 {
+				ret_err, cursor, keys = scan_data(db, cursor, pattern, limit)
+			}
+			if ret_err != 0 {
 				_, err = connection.Write([]byte("ERROR\n"))
 				if err != nil {
 					print_message("process_client: Error writing:" + err.Error())
 				}
+				continue
+			}
+			info = strconv.FormatUint(cursor, 10) + "\n" + strconv.Itoa(len(keys)) + "\n"
+			for _, key = range keys {
+				info = info + key + "\n"
+			}
+			_, err = connection.Write([]byte(info))
+			if err != nil {
+				print_message("process_client: Error writing:" + err.Error())
 			}
 			continue
 		}
@@ -632,10 +1864,15 @@ func process_client(connection net.Conn) int {
 				continue
 			}
 
-			// try to find matching path name
-			value = split_value(string(buffer[:mLen]))
+			// try to find matching path name, without a name save into the database save file
+			value = split_value(inputstr)
+			if value != "" {
+				value = database_root + value
+			} else if inputstr == SAVE_DATA {
+				value = get_database_file(db)
+			}
 			if value != "" {
-				if save_data(database_root+value) != 0 {
+				if save_data(db, value) != 0 {
 					_, err = connection.Write([]byte("ERROR\n"))
 					if err != nil {
 						print_message("process_client: Error writing:" + err.Error())
@@ -658,10 +1895,18 @@ func process_client(connection net.Conn) int {
 		// check load
 		match = strings.HasPrefix(inputstr, LOAD_DATA)
 		if match {
-			// try to find matching path name
-			value = split_value(string(buffer[:mLen]))
+			// try to find matching path name, without a name load the database save file
+			value = split_value(inputstr)
+			if value != "" {
+				value = database_root + value
+			} else if inputstr == LOAD_DATA {
+				value = get_database_file(db)
+			}
 			if value != "" {
-				if load_data(database_root+value) != 0 {
+				ret_err = load_data(db, value)
+				// write the imported data into the data log
+				log_import()
+				if ret_err != 0 {
 					_, err = connection.Write([]byte("ERROR\n"))
 					if err != nil {
 						print_message("process_client: Error writing:" + err.Error())
@@ -693,9 +1938,9 @@ func process_client(connection net.Conn) int {
 			}
 
 			// try to find matching path name
-			value = split_value(string(buffer[:mLen]))
+			value = split_value(inputstr)
 			if value != "" {
-				if save_data_json(database_root+value) != 0 {
+				if save_data_json(db, database_root+value) != 0 {
 					_, err = connection.Write([]byte("ERROR\n"))
 					if err != nil {
 						print_message("process_client: Error writing:" + err.Error())
@@ -719,9 +1964,12 @@ func process_client(connection net.Conn) int {
 		match = strings.HasPrefix(inputstr, LOAD_DATA_JSON)
 		if match {
 			// try to find matching path name
-			value = split_value(string(buffer[:mLen]))
+			value = split_value(inputstr)
 			if value != "" {
-				if load_data_json(database_root+value) != 0 {
+				ret_err = load_data_json(db, database_root+value)
+				// write the imported data into the data log
+				log_import()
+				if ret_err != 0 {
 					_, err = connection.Write([]byte("ERROR\n"))
 					if err != nil {
 						print_message("process_client: Error loading:" + err.Error())
@@ -753,9 +2001,9 @@ func process_client(connection net.Conn) int {
 			}
 
 			// try to find matching path name
-			value = split_value(string(buffer[:mLen]))
+			value = split_value(inputstr)
 			if value != "" {
-				if save_data_csv(database_root+value) != 0 {
+				if save_data_csv(db, database_root+value) != 0 {
 					_, err = connection.Write([]byte("ERROR\n"))
 					if err != nil {
 						print_message("process_client: Error writing:" + err.Error())
@@ -778,9 +2026,12 @@ func process_client(connection net.Conn) int {
 		match = strings.HasPrefix(inputstr, LOAD_DATA_CSV)
 		if match {
 			// try to find matching path name
-			value = split_value(string(buffer[:mLen]))
+			value = split_value(inputstr)
 			if value != "" {
-				if load_data_csv(database_root+value) != 0 {
+				ret_err = load_data_csv(db, database_root+value)
+				// write the imported data into the data log
+				log_import()
+				if ret_err != 0 {
 					_, err = connection.Write([]byte("ERROR\n"))
 					if err != nil {
 						print_message("process_client: Error writing:" + err.Error())
@@ -812,9 +2063,9 @@ func process_client(connection net.Conn) int {
 			}
 
 			// try to find matching path name
-			value = split_value(string(buffer[:mLen]))
+			value = split_value(inputstr)
 			if value != "" {
-				if save_data_table_csv(database_root+value) != 0 {
+				if save_data_table_csv(db, database_root+value) != 0 {
 					_, err = connection.Write([]byte("ERROR\n"))
 					if err != nil {
 						print_message("process_client: Error writing:" + err.Error())
@@ -838,9 +2089,12 @@ func process_client(connection net.Conn) int {
 		match = strings.HasPrefix(inputstr, LOAD_DATA_TABLE_CSV)
 		if match {
 			// try to find matching path name
-			value = split_value(string(buffer[:mLen]))
+			value = split_value(inputstr)
 			if value != "" {
-				if load_data_table_csv(database_root+value) != 0 {
+				ret_err = load_data_table_csv(db, database_root+value)
+				// write the imported data into the data log
+				log_import()
+				if ret_err != 0 {
 					_, err = connection.Write([]byte("ERROR\n"))
 					if err != nil {
 						print_message("process_client: Error writing:" + err.Error())
@@ -860,6 +2114,34 @@ func process_client(connection net.Conn) int {
 			continue
 		}
 
+		// write a new compact data log
+		match = strings.HasPrefix(inputstr, REWRITE_LOG)
+		if match {
+			if user_role == "read-only" || rewrite_log() != 0 {
+				_, err = connection.Write([]byte("ERROR\n"))
+				if err != nil {
+					print_message("process_client: Error writing:" + err.Error())
+				}
+			} else {
+				_, err = connection.Write([]byte("OK\n"))
+				if err != nil {
+					print_message("process_client: Error writing:" + err.Error())
+				}
+			}
+			continue
+		}
+
+		// get time of last successful save
+		match = strings.HasPrefix(inputstr, LAST_SAVE)
+		if match {
+			info = strconv.FormatInt(get_last_save(), 10) + "\n"
+			_, err = connection.Write([]byte(info))
+			if err != nil {
+				print_message("process_client: Error writing:" + err.Error())
+			}
+			continue
+		}
+
 		// check erase all data
 		// user role check
 		match = strings.HasPrefix(inputstr, ERASE_DATA)
@@ -869,7 +2151,7 @@ func process_client(connection net.Conn) int {
 			if user_role == "admin" {
 				match = strings.HasPrefix(inputstr, ERASE_DATA)
 				if match {
-					init_data()
+					erase_data(db)
 					_, err = connection.Write([]byte("OK\n"))
 					if err != nil {
 						print_message("process_client: Error writing:" + err.Error())
@@ -888,10 +2170,19 @@ func process_client(connection net.Conn) int {
 		// check get free elements
 		match = strings.HasPrefix(inputstr, GET_USED_ELEMENTS)
 		if match {
-			used_space = get_used_elements()
-			used_space_percent = float64(100 * used_space / maxdata)
-
-			info = "USAGE " + strconv.FormatFloat(used_space_percent, 'f', 2, 64) + "% : " + strconv.FormatUint(used_space, 10) + " of " + strconv.FormatUint(maxdata, 10) + "\n"
+			used_space, data_size = get_used_elements(db)
+			if data_size > 0 {
+				used_space_percent = 100.0 * float64(used_space) / float64(data_size)
+				info = "USAGE " + strconv.FormatFloat(used_space_percent, 'f', 2, 64) + "% : " + strconv.FormatUint(used_space, 10) + " of " + strconv.FormatUint(data_size, 10)
+			} else {
+				// no max number of keys, only the number of keys
+				info = "USAGE " + strconv.FormatUint(used_space, 10) + " keys"
+			}
+			used_memory, evicted = get_memory_usage()
+			if max_memory > 0 {
+				info = info + " : MEMORY " + strconv.FormatUint(used_memory, 10) + " of " + strconv.FormatUint(max_memory, 10)
+			}
+			info = info + " : EVICTED " + strconv.FormatUint(evicted, 10) + "\n"
 			_, err = connection.Write([]byte(info))
 			if err != nil {
 				print_message("process_client: Error sending space usage." + err.Error())
@@ -909,7 +2200,7 @@ func process_client(connection net.Conn) int {
 				continue
 			}
 
-			key = split_key(string(buffer[:mLen]))
+			key = split_key(inputstr)
 			if key == "" {
 				_, err = connection.Write([]byte("ERROR\n"))
 				if err != nil {
@@ -917,7 +2208,7 @@ func process_client(connection net.Conn) int {
 				}
 			}
 
-			value = split_value(string(buffer[:mLen]))
+			value = split_value(inputstr)
 			if value == "" {
 				_, err = connection.Write([]byte("ERROR\n"))
 				if err != nil {
@@ -925,7 +2216,7 @@ func process_client(connection net.Conn) int {
 				}
 			}
 
-			if set_link(key, value) != 0 {
+			if set_link(db, key, value) != 0 {
 				// error
 				_, err = connection.Write([]byte("ERROR\n"))
 				if err != nil {
@@ -950,7 +2241,7 @@ func process_client(connection net.Conn) int {
 				continue
 			}
 
-			key = split_key(string(buffer[:mLen]))
+			key = split_key(inputstr)
 			if key == "" {
 				_, err = connection.Write([]byte("ERROR\n"))
 				if err != nil {
@@ -958,7 +2249,7 @@ func process_client(connection net.Conn) int {
 				}
 			}
 
-			value = split_value(string(buffer[:mLen]))
+			value = split_value(inputstr)
 			if value == "" {
 				_, err = connection.Write([]byte("ERROR\n"))
 				if err != nil {
@@ -966,7 +2257,7 @@ func process_client(connection net.Conn) int {
 				}
 			}
 
-			if remove_link(key, value) != 0 {
+			if remove_link(db, key, value) != 0 {
 				// error
 				_, err = connection.Write([]byte("ERROR\n"))
 				if err != nil {
@@ -983,7 +2274,7 @@ func process_client(connection net.Conn) int {
 
 		match = strings.HasPrefix(inputstr, GET_LINKS_NUMBER)
 		if match {
-			key = split_key(string(buffer[:mLen]))
+			key = split_key(inputstr)
 			if key == "" {
 				_, err = connection.Write([]byte("ERROR\n"))
 				if err != nil {
@@ -991,7 +2282,7 @@ func process_client(connection net.Conn) int {
 				}
 			}
 
-			ret, retstring = get_number_of_links(key)
+			ret, retstring = get_number_of_links(db, key)
 			if retstring == "" {
 				// key not found
 				// error
@@ -1012,7 +2303,7 @@ func process_client(connection net.Conn) int {
 
 		match = strings.HasPrefix(inputstr, GET_LINK_NAME)
 		if match {
-			key = split_key(string(buffer[:mLen]))
+			key = split_key(inputstr)
 			if key == "" {
 				_, err = connection.Write([]byte("ERROR\n"))
 				if err != nil {
@@ -1020,7 +2311,7 @@ func process_client(connection net.Conn) int {
 				}
 			}
 
-			value = split_value(string(buffer[:mLen]))
+			value = split_value(inputstr)
 			if value == "" {
 				_, err = connection.Write([]byte("ERROR\n"))
 				if err != nil {
@@ -1030,7 +2321,7 @@ func process_client(connection net.Conn) int {
 
 			link, _ = strconv.ParseUint(value, 10, 64)
 
-			retstring = get_link(key, link)
+			retstring = get_link(db, key, link)
 			if retstring == "" {
 				// key not found
 				// error
@@ -1061,14 +2352,32 @@ func process_client(connection net.Conn) int {
 	return (return_value)
 }
 
+// get an optional number setting from the loaded config file "settings.l1db"
+// the number is not changed if the key is not set. returns 1 on error
+func get_setting_number(key string, number *uint64) int {
+	value := get_data_key(settings_db, key)
+	if value == "" {
+		return 0
+	}
+	setting, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		print_message("error: key ':" + key + "' in config file 'settings.l1db' is not a number!")
+		return 1
+	}
+	*number = setting
+	return 0
+}
+
 func main() {
-	// var user_maxdata uint64 = 0
+	var user_maxdata uint64 = 0
+	var user_max_memory uint64 = 0
+	var ret_err int = 0
 	var server_host_set bool = false
 	var server_port_set bool = false
 	var tls_flag_set bool = false
 	var server_http_port_set bool = false
 
-	print_message("l1vmgodata <ip> <port> <tls=on | tls=off> <http-port | off> [number of data entries]")
+	print_message("l1vmgodata <ip> <port> <tls=on | tls=off> <http-port | off> [max number of data entries]")
 	print_message("l1vmgodata start 0.9.7 ...")
 
 	fmt.Println("args: ", len(os.Args))
@@ -1086,11 +2395,11 @@ func main() {
 	}
 	if len(os.Args) == 6 {
 		// get maxdata from command line
-		user_maxdata, err := strconv.ParseInt(os.Args[5], 10, 64)
+		arg_maxdata, err := strconv.ParseUint(os.Args[5], 10, 64)
 		if err != nil {
 			panic(err)
 		}
-		maxdata = uint64(user_maxdata)
+		user_maxdata = arg_maxdata
 	}
 
 	if !read_ip_whitelist() {
@@ -1101,23 +2410,19 @@ func main() {
 		os.Exit(1)
 	}
 
-	fmt.Println("allocating ", maxdata , " space for data")
-
-	servdata := make([]data, maxdata) // make serverdata splice
-	pdata = &servdata
-
-	init_data()
+	// the settings get their own database, so they are not in the data of the clients
+	settings_db = new_database("settings")
+	init_databases()
 
 	// get configuration from: "settings.l1db"
-	if load_data(SETTINGS) != 0 {
+	if load_data(settings_db, SETTINGS) != 0 {
 		print_message("error: can't load config file 'settings.l1db'!")
-		init_data()
-		pdata = nil
+		init_databases()
 		os.Exit(1)
 	}
 
 	// get database root path
-	database_root = get_data_key("database-root\n")
+	database_root = get_data_key(settings_db, "database-root\n")
 	if database_root == "" {
 		print_message("error: can't get key ':database-root' from config file 'settings.l1db'!")
 	}
@@ -1126,7 +2431,7 @@ func main() {
 
 	// server host
 	if server_host_set == false {
-		server_host = get_data_key("host\n")
+		server_host = get_data_key(settings_db, "host\n")
 		if server_host == "" {
 			print_message("error can't get key ':host' from config file 'settings.l1db'!")
 		} else {
@@ -1136,7 +2441,7 @@ func main() {
 
 	// server port
 	if server_port_set == false {
-		server_port = get_data_key("port\n")
+		server_port = get_data_key(settings_db, "port\n")
 		if server_port == "" {
 			print_message("error: can't get key ':port' from config file 'settings.l1db'!")
 		} else {
@@ -1146,7 +2451,7 @@ func main() {
 
 	// tls flag
 	if tls_flag_set == false {
-		tls_flag = get_data_key("tls\n")
+		tls_flag = get_data_key(settings_db, "tls\n")
 		if tls_flag == "" {
 			print_message("error: can't get key ':tls' from config file 'settings.l1db'!")
 		} else {
@@ -1156,7 +2461,7 @@ func main() {
 
 	// server http port flag
 	if server_http_port_set == false {
-		server_http_port = get_data_key("http-port\n")
+		server_http_port = get_data_key(settings_db, "http-port\n")
 		if server_http_port == "" {
 			print_message("error: can't get key ':http-port' from config file 'settings.l1db'!")
 		} else {
@@ -1164,50 +2469,144 @@ func main() {
 		}
 	}
 
+	// max connections, max command line length and shutdown timeout, optional
+	if get_setting_number("max-connections", &max_connections) != 0 ||
+		get_setting_number("max-line-length", &max_line_len) != 0 ||
+		get_setting_number("shutdown-timeout", &shutdown_timeout) != 0 {
+		init_databases()
+		os.Exit(1)
+	}
+
 	// check if all needed config is set
 	if server_host_set == false {
 		print_message("Error: no server host set!")
-		init_data()
-		pdata = nil
+		init_databases()
 		os.Exit(1)
 	}
 
 	if server_port_set == false {
 		print_message("Error: no server port set!")
-		init_data()
-		pdata = nil
+		init_databases()
 		os.Exit(1)
 	}
 
 	if tls_flag_set == false {
 		fmt.Println("Error: no tls config set!")
-		init_data()
-		pdata = nil
+		init_databases()
 		os.Exit(1)
 	}
 
 	if server_http_port_set == false {
 		print_message("Error: no http port config set!")
-		init_data()
-		pdata = nil
+		init_databases()
+		os.Exit(1)
+	}
+
+	// data log, optional
+	log_file_path = get_data_key(settings_db, "log-file\n")
+	if log_file_path != "" && log_file_path != "off" {
+		log_file_path = database_root + log_file_path
+		if check_filename(log_file_path) == true {
+			os.Exit(1)
+		}
+	} else {
+		log_file_path = ""
+	}
+
+	value := get_data_key(settings_db, "log-fsync\n")
+	if value != "" {
+		if value == LOG_FSYNC_ALWAYS || value == LOG_FSYNC_EVERYSEC || value == LOG_FSYNC_NEVER {
+			log_fsync = value
+		} else {
+			print_message("error: key ':log-fsync' in config file 'settings.l1db' must be 'always', 'everysec' or 'never'!")
+		}
+	}
+
+	// final save on shutdown, optional
+	shutdown_save = get_data_key(settings_db, "shutdown-save")
+	if shutdown_save != "" && check_filename(database_root+shutdown_save) == true {
+		init_databases()
+		os.Exit(1)
+	}
+
+	// autosave, optional
+	if get_setting_number("autosave-interval", &autosave_interval) != 0 ||
+		get_setting_number("autosave-changes", &autosave_changes) != 0 ||
+		get_setting_number("autosave-keep", &autosave_keep) != 0 {
+		init_databases()
 		os.Exit(1)
 	}
 
+	// memory budget, optional
+	value = get_data_key(settings_db, "max-memory\n")
+	if value != "" {
+		ret_err, user_max_memory = get_memory_size(value)
+		if ret_err != 0 {
+			print_message("error: key ':max-memory' in config file 'settings.l1db' is not a number!")
+			os.Exit(1)
+		}
+	}
+	value = get_data_key(settings_db, "max-memory-policy\n")
+	if value != "" {
+		if check_eviction_policy(value) {
+			os.Exit(1)
+		}
+		max_memory_policy = value
+	}
+
 	// all config stuff load, clear config data base
-	init_data()
+	settings_db = nil
+
+	// the config data is not counted for the max data entries
+	maxdata = user_maxdata
+	if maxdata > 0 {
+		fmt.Println("max data entries: ", maxdata)
+	}
+
+	if log_file_path != "" {
+		// get the data from the last run
+		if replay_log() != 0 {
+			print_message("Error: can't replay data log " + log_file_path + "!")
+			os.Exit(1)
+		}
+		if open_log() != 0 {
+			os.Exit(1)
+		}
+		if log_fsync == LOG_FSYNC_EVERYSEC {
+			go sync_log_loop()
+		}
+		print_message("data log: " + log_file_path + " fsync: " + log_fsync)
+	}
+
+	// remove the expired keys
+	go expire_loop()
+	// put the reserved messages back into their lists after the visibility timeout
+	go queue_loop()
+
+	// the budget is set after the replay, so no keys of the data log are evicted
+	max_memory = user_max_memory
+	if max_memory > 0 {
+		print_message("max memory: " + strconv.FormatUint(max_memory, 10) + " bytes, policy: " + max_memory_policy)
+	}
+
+	if autosave_interval > 0 {
+		go autosave_loop()
+	}
+
+	handle_signals()
 
 	if tls_flag == "tls=on" {
 		print_message("running server: TLS on!")
 		tls_sock = true
 		run_server_tls()
-		init_data()
-		pdata = nil
+		shutdown()
+		init_databases()
 		os.Exit(0)
 	} else {
 		print_message("running server: normal socket!")
 		run_server()
-		init_data()
-		pdata = nil
+		shutdown()
+		init_databases()
 		os.Exit(0)
 	}
 }
@@ -0,0 +1,82 @@
+// l1vmgodata_test.go - database in go
+/*
+ * This file l1vmgodata_test.go is part of L1VMgodata.
+ *
+ * (c) Copyright Stefan Pietzonke (jay-t@gmx.net), 2025
+ *
+ * L1VMgodata is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * L1VMgodata is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with L1VMgodata.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+// reading the command lines of a client: the read buffer is smaller than the lines,
+// so a line is read in more than one part.
+
+package main
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"testing"
+)
+
+const (
+	READ_BUFFER_SIZE = 16 // the smallest size of a bufio.Reader
+)
+
+// a line read by read_line
+type read_result struct {
+	line string
+	err  error
+}
+
+func TestReadLine(t *testing.T) {
+	long := strings.Repeat("x", 100)
+
+	tests := []struct {
+		name    string
+		input   string
+		max_len uint64
+		results []read_result
+	}{
+		{"line longer than the read buffer", "store data :key '" + long + "'\n", 1024,
+			[]read_result{{"store data :key '" + long + "'", nil}, {"", io.EOF}}},
+		{"too long line and a valid line", long + "\r\nget key :key\n", 50,
+			[]read_result{{"", err_line_too_long}, {"get key :key", nil}, {"", io.EOF}}},
+		{"line with max length", strings.Repeat("y", 50) + "\r\n", 50,
+			[]read_result{{strings.Repeat("y", 50), nil}, {"", io.EOF}}},
+		{"pipelined lines in one write", "begin\nincr :n\r\ncommit\n", 1024,
+			[]read_result{{"begin", nil}, {"incr :n", nil}, {"commit", nil}, {"", io.EOF}}},
+		{"last line without line end", "get key :a\nget key :" + long, 1024,
+			[]read_result{{"get key :a", nil}, {"get key :" + long, nil}, {"", io.EOF}}},
+		{"too long last line without line end", "get key :a\n" + long, 50,
+			[]read_result{{"get key :a", nil}, {"", io.EOF}}},
+	}
+
+	saved_max_len := max_line_len
+	defer func() {
+		max_line_len = saved_max_len
+	}()
+
+	for _, test := range tests {
+		max_line_len = test.max_len
+		reader := bufio.NewReaderSize(strings.NewReader(test.input), READ_BUFFER_SIZE)
+		for n, want := range test.results {
+			line, err := read_line(reader)
+			if line != want.line || err != want.err {
+				t.Errorf("%s: line %d is %q, %v: expected %q, %v", test.name, n+1, line, err, want.line, want.err)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,313 @@
+// list.go - database in go
+/*
+ * This file list.go is part of L1VMgodata.
+ *
+ * (c) Copyright Stefan Pietzonke (jay-t@gmx.net), 2025
+ *
+ * L1VMgodata is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * L1VMgodata is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with L1VMgodata.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+// lists: a key of the type "list" has a list of elements instead of a value.
+// "lpush" and "rpush" add an element at the head or the tail, "lpop" and "rpop" remove it.
+// So a list is a queue between programs: one pushes at the tail, the other pops at the head.
+// A push to a key which is not set creates the list, a list without elements is removed.
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+)
+
+const (
+	LIST_MEMORY = 24 // memory of a list element without the value
+)
+
+// error codes of the list functions
+const (
+	LIST_OK       = 0
+	LIST_NOT_LIST = 1 // the key is not a list
+	LIST_ERROR    = 2 // no free space, or the key is not found
+	LIST_TIMEOUT  = 3 // no element was pushed in the time of a blocking pop
+	LIST_EMPTY    = 4 // the queue has no message to reserve
+)
+
+// add an element at the head (left) or the tail of a list, returns the new length of the list
+func push_list(db *database, key string, value string, left bool) (int, int) {
+	db.mutex.Lock()
+	err, length := push_list_locked(db, key, value, left)
+	db.mutex.Unlock()
+	return err, length
+}
+
+// db.mutex must be locked
+func push_list_locked(db *database, key string, value string, left bool) (int, int) {
+	var err int
+	var size uint64
+
+	// an expired key is stored as a new list
+	expire_key(db, key)
+
+	i, ok := db.key_index[key]
+	if ok && db.data[i].vtype != TYPE_LIST {
+		return LIST_NOT_LIST, 0
+	}
+
+	size = LIST_MEMORY + uint64(len(value))
+	if !ok {
+		size = size + DATA_ENTRY_MEMORY + 2*uint64(len(key))
+	}
+	if evict_data(db, key, size) != 0 {
+		return LIST_ERROR, 0
+	}
+
+	if !ok {
+		err, i = new_data(db, key)
+		if err == 1 {
+			fmt.Println("error: can't get free space for data!")
+			return LIST_ERROR, 0
+		}
+		db.data[i].vtype = TYPE_LIST
+		set_memory(db, db.memory+entry_memory(&db.data[i]))
+	} else {
+		change_entry(db, i)
+	}
+	if left {
+		db.data[i].list = append([]string{value}, db.data[i].list...)
+	} else {
+		db.data[i].list = append(db.data[i].list, value)
+	}
+	// the memory is not counted again, so a push to a long list is fast
+	set_memory(db, db.memory+LIST_MEMORY+uint64(len(value)))
+	update_list(db, i)
+
+	if left {
+		log_write(db, LOG_LPUSH, key, value, strconv.FormatInt(db.data[i].updated, 10))
+	} else {
+		log_write(db, LOG_RPUSH, key, value, strconv.FormatInt(db.data[i].updated, 10))
+	}
+	length := len(db.data[i].list)
+	serve_list_waiters(db, key)
+	return LIST_OK, length
+}
+
+// remove the element at the head (left) or the tail of a list, returns the element
+func pop_list(db *database, key string, left bool) (int, string) {
+	db.mutex.Lock()
+	err, value := pop_list_locked(db, key, left)
+	db.mutex.Unlock()
+	return err, value
+}
+
+// db.mutex must be locked
+func pop_list_locked(db *database, key string, left bool) (int, string) {
+	var value string
+
+	i, ok := get_key_index(db, key)
+	if !ok {
+		return LIST_ERROR, ""
+	}
+	if db.data[i].vtype != TYPE_LIST {
+		return LIST_NOT_LIST, ""
+	}
+	if len(db.data[i].list) == 0 {
+		return LIST_ERROR, ""
+	}
+
+	change_entry(db, i)
+	list := db.data[i].list
+	if left {
+		value = list[0]
+		// free the element, the list keeps its array
+		list[0] = ""
+		db.data[i].list = list[1:]
+	} else {
+		value = list[len(list)-1]
+		list[len(list)-1] = ""
+		db.data[i].list = list[:len(list)-1]
+	}
+	set_memory(db, db.memory-LIST_MEMORY-uint64(len(value)))
+	update_list(db, i)
+
+	if left {
+		log_write(db, LOG_LPOP, key, strconv.FormatInt(db.data[i].updated, 10))
+	} else {
+		log_write(db, LOG_RPOP, key, strconv.FormatInt(db.data[i].updated, 10))
+	}
+	remove_empty_list(db, i)
+	return LIST_OK, value
+}
+
+// get the elements from start to stop of a list, with both included.
+// A negative index counts from the end of the list: -1 is the last element.
+// A key which is not set is an empty list
+func get_list_range(db *database, key string, start int64, stop int64) (int, []string) {
+	db.mutex.RLock()
+	err, elements := get_list_range_locked(db, key, start, stop)
+	db.mutex.RUnlock()
+	return err, elements
+}
+
+// db.mutex must be locked
+func get_list_range_locked(db *database, key string, start int64, stop int64) (int, []string) {
+	i, ok := get_key_index(db, key)
+	if !ok {
+		return LIST_OK, nil
+	}
+	if db.data[i].vtype != TYPE_LIST {
+		return LIST_NOT_LIST, nil
+	}
+
+	length := int64(len(db.data[i].list))
+	if start < 0 {
+		start = start + length
+	}
+	if stop < 0 {
+		stop = stop + length
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= length {
+		stop = length - 1
+	}
+	if start > stop {
+		return LIST_OK, nil
+	}
+	return LIST_OK, append([]string(nil), db.data[i].list[start:stop+1]...)
+}
+
+// get the number of elements of a list, 0 if the key is not set
+func get_list_length(db *database, key string) (int, int) {
+	db.mutex.RLock()
+	err, length := get_list_length_locked(db, key)
+	db.mutex.RUnlock()
+	return err, length
+}
+
+// db.mutex must be locked
+func get_list_length_locked(db *database, key string) (int, int) {
+	i, ok := get_key_index(db, key)
+	if !ok {
+		return LIST_OK, 0
+	}
+	if db.data[i].vtype != TYPE_LIST {
+		return LIST_NOT_LIST, 0
+	}
+	return LIST_OK, len(db.data[i].list)
+}
+
+// remove elements with the value from a list: count > 0 the first count elements from the head,
+// count < 0 from the tail and count 0 all. Returns the number of removed elements
+func remove_list(db *database, key string, count int64, value string) (int, int) {
+	db.mutex.Lock()
+	err, removed := remove_list_locked(db, key, count, value)
+	db.mutex.Unlock()
+	return err, removed
+}
+
+// db.mutex must be locked
+func remove_list_locked(db *database, key string, count int64, value string) (int, int) {
+	var e int
+	var removed int = 0
+
+	i, ok := get_key_index(db, key)
+	if !ok {
+		return LIST_OK, 0
+	}
+	if db.data[i].vtype != TYPE_LIST {
+		return LIST_NOT_LIST, 0
+	}
+
+	list := db.data[i].list
+	remove := make([]bool, len(list))
+	if count >= 0 {
+		for e = 0; e < len(list) && (count == 0 || int64(removed) < count); e++ {
+			if list[e] == value {
+				remove[e] = true
+				removed++
+			}
+		}
+	} else {
+		for e = len(list) - 1; e >= 0 && int64(removed) < -count; e-- {
+			if list[e] == value {
+				remove[e] = true
+				removed++
+			}
+		}
+	}
+	if removed == 0 {
+		return LIST_OK, 0
+	}
+
+	change_entry(db, i)
+	before := entry_memory(&db.data[i])
+	kept := make([]string, 0, len(list)-removed)
+	for e = 0; e < len(list); e++ {
+		if !remove[e] {
+			kept = append(kept, list[e])
+		}
+	}
+	db.data[i].list = kept
+	update_memory(db, i, before)
+	update_list(db, i)
+
+	log_write(db, LOG_LREM, key, strconv.FormatInt(count, 10), value, strconv.FormatInt(db.data[i].updated, 10))
+	remove_empty_list(db, i)
+	return LIST_OK, removed
+}
+
+// the value of a list in a JSON export: ["element", ...]
+func format_list_json(list []string) string {
+	var value string = "["
+
+	for e, element := range list {
+		if e > 0 {
+			value = value + ", "
+		}
+		value = value + quote_json(element)
+	}
+	return value + "]"
+}
+
+// make a data entry a list with the elements, for loading a list. db.mutex must be locked
+func set_list(db *database, i uint64, elements []string) {
+	change_entry(db, i)
+	before := entry_memory(&db.data[i])
+	db.data[i].value = ""
+	db.data[i].vtype = TYPE_LIST
+	db.data[i].list = elements
+	update_memory(db, i, before)
+}
+
+// add an element at the tail of a list, for loading a list. db.mutex must be locked
+func add_list(db *database, i uint64, value string) {
+	change_entry(db, i)
+	db.data[i].list = append(db.data[i].list, value)
+	set_memory(db, db.memory+LIST_MEMORY+uint64(len(value)))
+}
+
+// set the update time and the version of a changed list, db.mutex must be locked
+func update_list(db *database, i uint64) {
+	db.data[i].updated = get_time_ms()
+	db.data[i].version++
+	touch_data(db, i)
+}
+
+// remove a list without elements and reserved messages, db.mutex must be locked
+func remove_empty_list(db *database, i uint64) {
+	if len(db.data[i].list) == 0 && len(db.data[i].reserved) == 0 {
+		delete_data(db, i)
+	}
+}
@@ -0,0 +1,111 @@
+// meta.go - database in go
+/*
+ * This file meta.go is part of L1VMgodata.
+ *
+ * (c) Copyright Stefan Pietzonke (jay-t@gmx.net), 2025
+ *
+ * L1VMgodata is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * L1VMgodata is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with L1VMgodata.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+// key metadata: every key has the time it was created, the time its value was updated
+// and a version, which counts the stores of the value.
+// A client can store a key only if the version didn't change since it read the key: "store data if-version".
+// Keys from files without metadata get the load time and version 1.
+
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+// set the metadata of a data entry, db.mutex must be locked
+func set_meta(db *database, i uint64, created int64, updated int64, version uint64) {
+	change_entry(db, i)
+	db.data[i].created = created
+	db.data[i].updated = updated
+	db.data[i].version = version
+}
+
+// get the metadata of a key: created, updated and version
+// returns 1 if the key is not found
+func get_meta(db *database, key string) (int, int64, int64, uint64) {
+	db.mutex.RLock()
+	err, created, updated, version := get_meta_locked(db, key)
+	db.mutex.RUnlock()
+	return err, created, updated, version
+}
+
+// db.mutex must be locked
+func get_meta_locked(db *database, key string) (int, int64, int64, uint64) {
+	i, ok := get_key_index(db, key)
+	if !ok {
+		return 1, 0, 0, 0
+	}
+	return 0, db.data[i].created, db.data[i].updated, db.data[i].version
+}
+
+// the reply of the "meta" command
+func format_meta(created int64, updated int64, version uint64) string {
+	return "META created " + strconv.FormatInt(created, 10) + " : updated " + strconv.FormatInt(updated, 10) +
+		" : version " + strconv.FormatUint(version, 10)
+}
+
+// the metadata in the save file: ":meta "created updated version""
+func format_meta_save(d *data) string {
+	return strconv.FormatInt(d.created, 10) + " " + strconv.FormatInt(d.updated, 10) + " " + strconv.FormatUint(d.version, 10)
+}
+
+// get the metadata of the save file line ":meta "created updated version""
+// returns 1 on error
+func split_meta_save(value string) (int, int64, int64, uint64) {
+	fields := strings.Fields(value)
+	if len(fields) != 3 {
+		return 1, 0, 0, 0
+	}
+	created, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return 1, 0, 0, 0
+	}
+	updated, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return 1, 0, 0, 0
+	}
+	version, err := strconv.ParseUint(fields[2], 10, 64)
+	if err != nil {
+		return 1, 0, 0, 0
+	}
+	return 0, created, updated, version
+}
+
+// set the update time of a key from a replayed store, a new key was also created then.
+// returns 1 on error
+func set_store_time(db *database, key string, updated string) int {
+	ms, err := strconv.ParseInt(updated, 10, 64)
+	if err != nil {
+		return 1
+	}
+
+	db.mutex.Lock()
+	i, ok := db.key_index[key]
+	if ok {
+		created := db.data[i].created
+		if db.data[i].version == 1 {
+			created = ms
+		}
+		set_meta(db, i, created, ms, db.data[i].version)
+	}
+	db.mutex.Unlock()
+	return 0
+}
@@ -0,0 +1,433 @@
+// queue.go - database in go
+/*
+ * This file queue.go is part of L1VMgodata.
+ *
+ * (c) Copyright Stefan Pietzonke (jay-t@gmx.net), 2025
+ *
+ * L1VMgodata is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * L1VMgodata is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with L1VMgodata.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+// reliable queues: a list is a queue with at-least-once delivery.
+// "reserve" takes the element at the head of the list and keeps it as reserved message with an id,
+// until the consumer acknowledges it with "ack". A message which is not acknowledged
+// in the visibility timeout, or is given back with "nack", is put back at the head of the list.
+// So the message of a consumer which died is delivered to the next one.
+// The reserved messages are saved with the list and are kept after a restart.
+// The counters of a queue are kept in the database, also if the list is removed because it is empty.
+// They are removed with the key by "remove", the eviction or the expire time.
+
+package main
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	QUEUE_VISIBILITY = 30 // default visibility timeout of a reserved message in seconds
+	RESERVED_MEMORY  = 40 // memory of a reserved message without the value
+	QUEUE_MEMORY     = 80 // memory of the counters of a queue without the key
+)
+
+// a message of a list reserved by a consumer
+type reserved_message struct {
+	id       uint64
+	deadline int64 // unix time in milliseconds when the message is put back into the list
+	value    string
+}
+
+// the message ids and counters of a list used as queue
+type queue_counter struct {
+	last_id     uint64 // id of the last reserved message
+	delivered   uint64 // number of reserved messages
+	acked       uint64 // number of acknowledged messages
+	redelivered uint64 // number of messages put back into the list
+}
+
+// reserve the element at the head of a list for seconds, returns the id of the message and the element
+func reserve_queue(db *database, key string, seconds uint64) (int, uint64, string) {
+	db.mutex.Lock()
+	err, id, value := reserve_queue_locked(db, key, 0, get_time_ms()+int64(seconds)*1000)
+	db.mutex.Unlock()
+	return err, id, value
+}
+
+// reserve the message with the id until the deadline, a new id if it is 0. db.mutex must be locked
+func reserve_queue_locked(db *database, key string, id uint64, deadline int64) (int, uint64, string) {
+	i, ok := get_key_index(db, key)
+	if !ok {
+		// a queue without messages
+		return LIST_EMPTY, 0, ""
+	}
+	d := &db.data[i]
+	if d.vtype != TYPE_LIST {
+		return LIST_NOT_LIST, 0, ""
+	}
+	if len(d.list) == 0 {
+		return LIST_EMPTY, 0, ""
+	}
+
+	counter := change_queue(db, key)
+	if id == 0 {
+		// the ids are unique, also if the list is removed and set again
+		id = counter.last_id + 1
+		now_id := uint64(get_time_ms()) * 1000
+		if id < now_id {
+			id = now_id
+		}
+	}
+
+	change_entry(db, i)
+	before := entry_memory(d)
+	value := d.list[0]
+	d.list[0] = ""
+	d.list = d.list[1:]
+	d.reserved = append(d.reserved, reserved_message{id: id, deadline: deadline, value: value})
+	if id > counter.last_id {
+		counter.last_id = id
+	}
+	counter.delivered++
+	update_memory(db, i, before)
+	db.reserving[key] = true
+	update_list(db, i)
+
+	log_write(db, LOG_RESERVE, key, strconv.FormatUint(id, 10), strconv.FormatInt(deadline, 10), strconv.FormatInt(d.updated, 10))
+	return LIST_OK, id, value
+}
+
+// acknowledge a reserved message, it is removed.
+// returns LIST_ERROR if the message is not reserved
+func ack_queue(db *database, key string, id uint64) int {
+	db.mutex.Lock()
+	err := ack_queue_locked(db, key, id)
+	db.mutex.Unlock()
+	return err
+}
+
+// db.mutex must be locked
+func ack_queue_locked(db *database, key string, id uint64) int {
+	err, i, r := get_reserved(db, key, id)
+	if err != LIST_OK {
+		return err
+	}
+	d := &db.data[i]
+
+	change_entry(db, i)
+	before := entry_memory(d)
+	d.reserved = remove_element_by_index(d.reserved, uint64(r))
+	change_queue(db, key).acked++
+	update_memory(db, i, before)
+	update_list(db, i)
+
+	log_write(db, LOG_ACK, key, strconv.FormatUint(id, 10), strconv.FormatInt(d.updated, 10))
+	finish_reserved(db, i)
+	return LIST_OK
+}
+
+// put a reserved message back at the head of the list, for the next consumer.
+// returns LIST_ERROR if the message is not reserved
+func nack_queue(db *database, key string, id uint64) int {
+	db.mutex.Lock()
+	err := nack_queue_locked(db, key, id)
+	db.mutex.Unlock()
+	return err
+}
+
+// db.mutex must be locked
+func nack_queue_locked(db *database, key string, id uint64) int {
+	err, i, r := get_reserved(db, key, id)
+	if err != LIST_OK {
+		return err
+	}
+	release_reserved(db, i, r)
+	return LIST_OK
+}
+
+// get the data index of a list and the index of a reserved message, db.mutex must be locked
+func get_reserved(db *database, key string, id uint64) (int, uint64, int) {
+	i, ok := get_key_index(db, key)
+	if !ok {
+		return LIST_ERROR, 0, 0
+	}
+	if db.data[i].vtype != TYPE_LIST {
+		return LIST_NOT_LIST, 0, 0
+	}
+	for r, message := range db.data[i].reserved {
+		if message.id == id {
+			return LIST_OK, i, r
+		}
+	}
+	return LIST_ERROR, 0, 0
+}
+
+// put the reserved message r back at the head of the list, db.mutex must be locked
+func release_reserved(db *database, i uint64, r int) {
+	d := &db.data[i]
+	key := d.key
+	message := d.reserved[r]
+
+	change_entry(db, i)
+	before := entry_memory(d)
+	d.reserved = remove_element_by_index(d.reserved, uint64(r))
+	d.list = append([]string{message.value}, d.list...)
+	change_queue(db, key).redelivered++
+	update_memory(db, i, before)
+	update_list(db, i)
+
+	log_write(db, LOG_RELEASE, key, strconv.FormatUint(message.id, 10), strconv.FormatInt(d.updated, 10))
+	finish_reserved(db, i)
+	serve_list_waiters(db, key)
+}
+
+// remove a list from the lists with reserved messages, if it has none. db.mutex must be locked
+func finish_reserved(db *database, i uint64) {
+	if len(db.data[i].reserved) == 0 {
+		delete(db.reserving, db.data[i].key)
+	}
+	remove_empty_list(db, i)
+}
+
+// get the element at the head of a list, without removing it.
+// returns LIST_EMPTY if the list has no elements
+func peek_queue(db *database, key string) (int, string) {
+	db.mutex.RLock()
+	err, value := peek_queue_locked(db, key)
+	db.mutex.RUnlock()
+	return err, value
+}
+
+// db.mutex must be locked
+func peek_queue_locked(db *database, key string) (int, string) {
+	err, elements := get_list_range_locked(db, key, 0, 0)
+	if err != LIST_OK {
+		return err, ""
+	}
+	if len(elements) == 0 {
+		return LIST_EMPTY, ""
+	}
+	return LIST_OK, elements[0]
+}
+
+// get the number of ready and reserved messages and the counters of a queue.
+// A key which is not set is an empty queue, it has the counters of the removed list
+func get_queue_stats(db *database, key string) (int, int, int, queue_counter) {
+	db.mutex.RLock()
+	err, ready, reserved, counter := get_queue_stats_locked(db, key)
+	db.mutex.RUnlock()
+	return err, ready, reserved, counter
+}
+
+// db.mutex must be locked
+func get_queue_stats_locked(db *database, key string) (int, int, int, queue_counter) {
+	var counter queue_counter
+
+	i, ok := get_key_index(db, key)
+	if ok && db.data[i].vtype != TYPE_LIST {
+		return LIST_NOT_LIST, 0, 0, counter
+	}
+	saved, found := db.queues[key]
+	if found {
+		counter = *saved
+	}
+	if !ok {
+		return LIST_OK, 0, 0, counter
+	}
+	return LIST_OK, len(db.data[i].list), len(db.data[i].reserved), counter
+}
+
+// get the counters of a queue to change them, a queue without counters gets new ones. db.mutex must be locked.
+// In a transaction the counters are saved first, so the change can be undone
+func change_queue(db *database, key string) *queue_counter {
+	change_queues(db)
+	counter, ok := db.queues[key]
+	if !ok {
+		counter = &queue_counter{}
+		db.queues[key] = counter
+		set_memory(db, db.memory+QUEUE_MEMORY+uint64(len(key)))
+	}
+	return counter
+}
+
+// remove the counters of a queue with its key, db.mutex must be locked.
+// returns false if the key has no counters
+func remove_queue(db *database, key string) bool {
+	_, ok := db.queues[key]
+	if !ok {
+		return false
+	}
+	change_queues(db)
+	delete(db.queues, key)
+	set_memory(db, db.memory-QUEUE_MEMORY-uint64(len(key)))
+	return true
+}
+
+// get a copy of the counters of all queues, for a save. db.mutex must be locked
+func get_queue_snapshot_locked(db *database) map[string]queue_counter {
+	snapshot := make(map[string]queue_counter, len(db.queues))
+	for key, counter := range db.queues {
+		snapshot[key] = *counter
+	}
+	return snapshot
+}
+
+// the reply of the "stats" command
+func format_queue_stats(ready int, reserved int, counter queue_counter) string {
+	return "QUEUE ready " + strconv.Itoa(ready) + " : reserved " + strconv.Itoa(reserved) +
+		" : delivered " + strconv.FormatUint(counter.delivered, 10) + " : acked " + strconv.FormatUint(counter.acked, 10) +
+		" : redelivered " + strconv.FormatUint(counter.redelivered, 10)
+}
+
+// add a reserved message to a list, for loading a list. A list without elements is set.
+// db.mutex must be locked
+// returns 1 if there is no free space
+func add_reserved(db *database, key string, message reserved_message) int {
+	var err int
+
+	i, ok := db.key_index[key]
+	if !ok {
+		err, i = new_data(db, key)
+		if err == 1 {
+			return 1
+		}
+		db.data[i].vtype = TYPE_LIST
+		set_memory(db, db.memory+entry_memory(&db.data[i]))
+	} else if db.data[i].vtype != TYPE_LIST {
+		return 1
+	}
+
+	change_entry(db, i)
+	before := entry_memory(&db.data[i])
+	db.data[i].reserved = append(db.data[i].reserved, message)
+	counter := change_queue(db, key)
+	if message.id > counter.last_id {
+		counter.last_id = message.id
+	}
+	update_memory(db, i, before)
+	db.reserving[key] = true
+	return 0
+}
+
+// set the counters of a queue, for loading a database. db.mutex must be locked
+func set_queue_counter(db *database, key string, counter queue_counter) {
+	*change_queue(db, key) = counter
+}
+
+// the counters of a queue in the save file and the data log: "last_id delivered acked redelivered"
+func format_queue_save(counter queue_counter) string {
+	return strconv.FormatUint(counter.last_id, 10) + " " + strconv.FormatUint(counter.delivered, 10) + " " +
+		strconv.FormatUint(counter.acked, 10) + " " + strconv.FormatUint(counter.redelivered, 10)
+}
+
+// get the counters of a save file line ":queue "last_id delivered acked redelivered""
+// returns 1 on error
+func split_queue_save(input string) (int, queue_counter) {
+	var counter queue_counter
+	var numbers [4]uint64
+	var err error
+
+	fields := strings.Fields(input)
+	if len(fields) != 4 {
+		return 1, counter
+	}
+	for n := range fields {
+		numbers[n], err = strconv.ParseUint(fields[n], 10, 64)
+		if err != nil {
+			return 1, counter
+		}
+	}
+	counter.last_id = numbers[0]
+	counter.delivered = numbers[1]
+	counter.acked = numbers[2]
+	counter.redelivered = numbers[3]
+	return 0, counter
+}
+
+// get the counters and the key of a save file line ":queue "last_id delivered acked redelivered key""
+// returns 1 on error
+func split_queue_key_save(input string) (int, string, queue_counter) {
+	fields := strings.SplitN(input, " ", 5)
+	if len(fields) != 5 || fields[4] == "" {
+		return 1, "", queue_counter{}
+	}
+	err, counter := split_queue_save(strings.Join(fields[:4], " "))
+	if err != 0 {
+		return 1, "", queue_counter{}
+	}
+	return 0, fields[4], counter
+}
+
+// the reserved message in the save file and the data log: "id deadline value"
+func format_reserved_save(message reserved_message) string {
+	return strconv.FormatUint(message.id, 10) + " " + strconv.FormatInt(message.deadline, 10) + " " + message.value
+}
+
+// get the reserved message of a save file line ":reserved "id deadline value""
+// returns 1 on error
+func split_reserved_save(input string) (int, reserved_message) {
+	var message reserved_message
+	var err error
+
+	fields := strings.SplitN(input, " ", 3)
+	if len(fields) != 3 {
+		return 1, message
+	}
+	message.id, err = strconv.ParseUint(fields[0], 10, 64)
+	if err != nil || message.id == 0 {
+		return 1, message
+	}
+	message.deadline, err = strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return 1, message
+	}
+	message.value = fields[2]
+	return 0, message
+}
+
+// put the reserved messages back into their lists after the visibility timeout, every second
+func queue_loop() {
+	for {
+		time.Sleep(time.Second)
+
+		for _, db := range get_databases() {
+			release_expired(db)
+		}
+	}
+}
+
+// put the reserved messages of a database with a past deadline back into their lists
+func release_expired(db *database) {
+	var r int
+
+	db.mutex.Lock()
+	now := get_time_ms()
+	for key := range db.reserving {
+		i, ok := db.key_index[key]
+		if !ok {
+			delete(db.reserving, key)
+			continue
+		}
+		for r = 0; r < len(db.data[i].reserved); {
+			if db.data[i].reserved[r].deadline <= now {
+				release_reserved(db, i, r)
+				if !db.data[i].used || db.data[i].key != key {
+					break
+				}
+			} else {
+				r++
+			}
+		}
+	}
+	db.mutex.Unlock()
+}
@@ -23,7 +23,11 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"math"
+	"strconv"
 	"strings"
 )
 
@@ -101,7 +105,6 @@ func check_data(input string) int {
 
 func split_data(input string) (string, string) {
 	var i int = 0
-	var j int = 0
 	var search bool = true
 	var copy bool = true
 	var inkey string = ""
@@ -138,104 +141,165 @@ func split_data(input string) (string, string) {
 		i++
 	}
 
-	// read chars into data
-	for j = i; j < inplen; j++ {
-		if input[i] != '\'' {
-			if input[i] != '"' {
-				invalue = invalue + string(input[i])
-			}
-		}
-		i++
+	// read chars into data: the value starts after the space behind the key.
+	// Only the quotes around the value are removed, so a value can have quotes
+	if i-1 < inplen {
+		invalue = strings.TrimSpace(input[i-1:])
+	}
+	if strings.HasPrefix(invalue, "'") || strings.HasPrefix(invalue, "\"") {
+		invalue = invalue[1:]
+	}
+	if strings.HasSuffix(invalue, "'") || strings.HasSuffix(invalue, "\"") {
+		invalue = invalue[:len(invalue)-1]
 	}
 	return inkey, invalue
 }
 
+// get the key and the value of a JSON export line:
+// { "key": "foo", "value": "bar" }
+// returns empty strings on error
 func split_data_json(input string) (string, string) {
-	var i int = 0
-	var search bool = true
-	var copy bool = true
+	var err int = 0
 	var inkey string = ""
 	var invalue string = ""
-	var inplen int = 0
-	var pos int = 0
-	var asciicode int = 0
-	inplen = len(input)
+
 	// search for: "key":
-	pos = strings.Index(input, "\"key\":")
+	pos := strings.Index(input, "\"key\":")
 	if pos == -1 {
 		// error: key part not found in string line
 		return "", ""
 	}
-	// get key
-	i = pos + 6
-	for search {
-		asciicode = int(input[i])
-		if asciicode == 34 {
-			// found quote char
-			if i >= inplen {
-				copy = false
-				search = false
-				return "", ""
-			}
-			i++
-			for copy {
-				asciicode = int(input[i])
-				if asciicode != 34 {
-					inkey = inkey + string(input[i])
-				} else {
-					copy = false
-					search = false
-				}
-				i++
-				if i >= inplen {
-					copy = false
-					search = false
-				}
-
-			}
-		}
-		i++
+	err, inkey, input = split_string_json(strings.TrimSpace(input[pos+6:]))
+	if err != 0 {
+		return "", ""
 	}
 
-	search = true
-	copy = true
+	// the value follows the key
 	pos = strings.Index(input, "\"value\":")
 	if pos == -1 {
-		// error: key part not found in string line
+		// error: value part not found in string line
 		return "", ""
 	}
-	// get value
-	i = pos + 8
-	for search {
-		asciicode = int(input[i])
-		if asciicode == 34 {
-			// found quote char
-			if i >= inplen {
-				copy = false
-				search = false
-				return "", ""
-			}
-			i++
-			for copy {
-				asciicode = int(input[i])
-				if asciicode != 34 {
-					invalue = invalue + string(input[i])
-				} else {
-					copy = false
-					search = false
-				}
-				i++
-				if i >= inplen {
-					copy = false
-					search = false
-				}
+	value := strings.TrimSpace(input[pos+8:])
+	if value != "" && value[0] != '"' {
+		// a number or a boolean of a typed value is not quoted, a list is an array
+		end := strings.IndexAny(value, " ,}")
+		if end != -1 {
+			value = value[:end]
+		}
+		return inkey, value
+	}
+	err, invalue, _ = split_string_json(value)
+	if err != 0 {
+		return "", ""
+	}
+	return inkey, invalue
+}
+
+// get the JSON string at the start of the input, with the escaped characters decoded.
+// returns the string and the input after it, 1 on error
+func split_string_json(input string) (int, string, string) {
+	var value string
 
+	if !strings.HasPrefix(input, "\"") {
+		return 1, "", input
+	}
+	for i := 1; i < len(input); i++ {
+		switch input[i] {
+		case '\\':
+			// skip the escaped character
+			i++
+		case '"':
+			if json.Unmarshal([]byte(input[:i+1]), &value) != nil {
+				return 1, "", input
 			}
+			return 0, value, input[i+1:]
 		}
-		i++
 	}
+	return 1, "", input
+}
 
-	return inkey, invalue
+// encode a string as JSON string, with quotes
+func quote_json(value string) string {
+	var buffer bytes.Buffer
+
+	encoder := json.NewEncoder(&buffer)
+	// keep "<", ">" and "&" readable
+	encoder.SetEscapeHTML(false)
+	if encoder.Encode(value) != nil {
+		return "\"\""
+	}
+	return strings.TrimSuffix(buffer.String(), "\n")
+}
+
+// get the type field of a JSON export line, it follows the value:
+// { "key": "foo", "value": 42, "type": "int" }
+// returns "string" if it is not set
+func split_type_json(input string) string {
+	pos := strings.LastIndex(input, "\"type\": \"")
+	if pos == -1 {
+		return TYPE_STRING
+	}
+	vtype := input[pos+9:]
+	end := strings.Index(vtype, "\"")
+	if end == -1 {
+		return TYPE_STRING
+	}
+	return vtype[:end]
+}
+
+// get the elements of a list value of a JSON export line:
+// { "key": "queue", "value": ["job-1", "job-2"], "type": "list" }
+// returns 1 on error
+func split_list_json(input string) (int, []string) {
+	var elements []string
+
+	pos := strings.Index(input, "\"value\":")
+	if pos == -1 {
+		return 1, nil
+	}
+	list := strings.TrimSpace(input[pos+8:])
+	if !strings.HasPrefix(list, "[") {
+		return 1, nil
+	}
+	list = strings.TrimSpace(list[1:])
+	for !strings.HasPrefix(list, "]") {
+		err, element, rest := split_string_json(list)
+		if err != 0 {
+			return 1, nil
+		}
+		elements = append(elements, element)
+		list = strings.TrimSpace(rest)
+		if strings.HasPrefix(list, ",") {
+			list = strings.TrimSpace(list[1:])
+		} else if !strings.HasPrefix(list, "]") {
+			return 1, nil
+		}
+	}
+	return 0, elements
+}
+
+// get a number field of a JSON export line, the fields after the value are numbers:
+// { "key": "foo", "value": "bar", "expire": 1760000000000, "version": 3 }
+// returns 0 if it is not set
+func split_number_json(input string, name string) int64 {
+	var pos int = 0
+
+	pos = strings.LastIndex(input, "\""+name+"\": ")
+	if pos == -1 {
+		return 0
+	}
+	// the number ends before the next field or the closing bracket
+	number := strings.TrimSpace(input[pos+len(name)+4:])
+	end := strings.IndexAny(number, " ,}")
+	if end != -1 {
+		number = number[:end]
+	}
+	value, err := strconv.ParseInt(number, 10, 64)
+	if err != nil {
+		return 0
+	}
+	return value
 }
 
 func split_data_csv(input string) (string, string) {
@@ -466,3 +530,287 @@ func split_value(input string) string {
 	}
 	return invalue
 }
+
+// get the "limit <n>" and "offset <n>" options of the list commands.
+// They are set after the search key or the quoted search value:
+// list regex key :^1-.* limit 10 offset 20
+// returns 1 on error
+func split_list_options(input string) (int, uint64, uint64) {
+	var i int = 0
+	var limit uint64 = 0
+	var offset uint64 = 0
+	var options string = ""
+	var pos int = 0
+	var err error
+
+	pos = strings.LastIndex(input, "'")
+	if pos != -1 {
+		options = input[pos+1:]
+	} else {
+		pos = strings.Index(input, ":")
+		if pos != -1 {
+			options = input[pos:]
+			pos = strings.Index(options, " ")
+			if pos != -1 {
+				options = options[pos:]
+			} else {
+				options = ""
+			}
+		}
+	}
+
+	fields := strings.Fields(options)
+	for i = 0; i < len(fields); i++ {
+		if i+1 >= len(fields) {
+			// option without number
+			return 1, 0, 0
+		}
+		switch strings.ToLower(fields[i]) {
+		case "limit":
+			limit, err = strconv.ParseUint(fields[i+1], 10, 64)
+		case "offset":
+			offset, err = strconv.ParseUint(fields[i+1], 10, 64)
+		default:
+			fmt.Println("split_list_options: error unknown option: " + fields[i])
+			return 1, 0, 0
+		}
+		if err != nil {
+			fmt.Println("split_list_options: error option is not a number: " + fields[i+1])
+			return 1, 0, 0
+		}
+		i++
+	}
+	return 0, limit, offset
+}
+
+// get the two quoted values of the "cas" and "lrem" commands:
+// cas :key 'expected' 'new', lrem :key 'count' 'value'
+// returns 1 on error
+func split_cas_values(input string) (int, string, string) {
+	var quotes []int
+	var i int
+
+	for i = 0; i < len(input); i++ {
+		if input[i] == '\'' {
+			quotes = append(quotes, i)
+		}
+	}
+	if len(quotes) != 4 || strings.Index(input, ":") > quotes[0] {
+		fmt.Println("split_cas_values: error no key and two quoted values found!")
+		return 1, "", ""
+	}
+	return 0, input[quotes[0]+1 : quotes[1]], input[quotes[2]+1 : quotes[3]]
+}
+
+// get a number of seconds for a time to live, it must be more than 0
+// returns 1 on error
+func split_seconds(input string) (int, uint64) {
+	seconds, err := strconv.ParseUint(strings.TrimSpace(input), 10, 64)
+	if err != nil || seconds == 0 || seconds > TTL_MAX_SECONDS {
+		fmt.Println("split_seconds: error not a valid number of seconds: " + input)
+		return 1, 0
+	}
+	return 0, seconds
+}
+
+// get the timeout in seconds of the "blpop" and "brpop" commands, 0 = no timeout:
+// blpop :key 'seconds'
+// returns 1 on error
+func split_timeout(input string) (int, uint64) {
+	value := split_value(input)
+	if value == "" {
+		fmt.Println("split_timeout: error no timeout set!")
+		return 1, 0
+	}
+	seconds, err := strconv.ParseUint(value, 10, 64)
+	if err != nil || seconds > TTL_MAX_SECONDS {
+		fmt.Println("split_timeout: error not a valid number of seconds: " + value)
+		return 1, 0
+	}
+	return 0, seconds
+}
+
+// get the number of the "incr" and "decr" commands, 1 if it is not set:
+// incr :key ['n']
+// returns 1 on error
+func split_number(input string) (int, int64) {
+	value := split_value(input)
+	if value == "" {
+		return 0, 1
+	}
+	number, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || number == math.MinInt64 {
+		fmt.Println("split_number: error not a valid number: " + value)
+		return 1, 0
+	}
+	return 0, number
+}
+
+// get the floating point number of the "incrfloat" command, 1.0 if it is not set:
+// incrfloat :key ['f']
+// returns 1 on error
+func split_number_float(input string) (int, float64) {
+	value := split_value(input)
+	if value == "" {
+		return 0, 1.0
+	}
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsNaN(number) || math.IsInf(number, 0) {
+		fmt.Println("split_number_float: error not a valid number: " + value)
+		return 1, 0
+	}
+	return 0, number
+}
+
+// get the seconds of the "store data ttl" command:
+// store data ttl <seconds> :key 'value'
+// returns 1 on error
+func split_ttl(input string) (int, uint64) {
+	fields := strings.Fields(strings.TrimPrefix(input, STORE_DATA_TTL))
+	if len(fields) == 0 {
+		fmt.Println("split_ttl: error no seconds set!")
+		return 1, 0
+	}
+	return split_seconds(fields[0])
+}
+
+// get the version of the "store data if-version" command:
+// store data if-version <version> :key 'value'
+// returns 1 on error
+func split_version(input string) (int, string) {
+	fields := strings.Fields(strings.TrimPrefix(input, STORE_DATA_IF_VERSION))
+	if len(fields) == 0 {
+		fmt.Println("split_version: error no version set!")
+		return 1, ""
+	}
+	_, err := strconv.ParseUint(fields[0], 10, 64)
+	if err != nil {
+		fmt.Println("split_version: error not a valid version: " + fields[0])
+		return 1, ""
+	}
+	return 0, fields[0]
+}
+
+// get the type of the "store data type" command:
+// store data type <type> :key 'value'
+// returns 1 on error
+func split_type(input string) (int, string) {
+	fields := strings.Fields(strings.TrimPrefix(input, STORE_DATA_TYPE))
+	if len(fields) == 0 {
+		fmt.Println("split_type: error no type set!")
+		return 1, ""
+	}
+	if check_type_name(fields[0]) {
+		return 1, ""
+	}
+	return 0, fields[0]
+}
+
+// get the start and stop index of the "lrange" command, without them the whole list:
+// lrange :key 'start stop'
+// returns 1 on error
+func split_range(input string) (int, int64, int64) {
+	fields := strings.Fields(split_value(input))
+	if len(fields) == 0 {
+		return 0, 0, -1
+	}
+	if len(fields) != 2 {
+		fmt.Println("split_range: error no start and stop index found!")
+		return 1, 0, 0
+	}
+	start, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		fmt.Println("split_range: error start index is not a number: " + fields[0])
+		return 1, 0, 0
+	}
+	stop, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		fmt.Println("split_range: error stop index is not a number: " + fields[1])
+		return 1, 0, 0
+	}
+	return 0, start, stop
+}
+
+// get the time of the "get key at" command, in unix milliseconds:
+// get key at <time> :key
+// returns 1 on error
+func split_time(input string) (int, int64) {
+	fields := strings.Fields(strings.TrimPrefix(input, GET_DATA_KEY_AT))
+	if len(fields) == 0 {
+		fmt.Println("split_time: error no time set!")
+		return 1, 0
+	}
+	ms, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		fmt.Println("split_time: error not a valid time: " + fields[0])
+		return 1, 0
+	}
+	return 0, ms
+}
+
+// get the operator and the number of the "list compare" command:
+// list compare '>= 10'
+// returns 1 on error
+func split_compare(input string) (int, string, float64) {
+	fields := strings.Fields(split_value(input))
+	if len(fields) != 2 {
+		fmt.Println("split_compare: error no operator and number found!")
+		return 1, "", 0
+	}
+	switch fields[0] {
+	case "<", "<=", ">", ">=", "==", "!=":
+	default:
+		fmt.Println("split_compare: error operator is not known: " + fields[0])
+		return 1, "", 0
+	}
+	number, err := strconv.ParseFloat(fields[1], 64)
+	if err != nil || math.IsNaN(number) {
+		fmt.Println("split_compare: error not a valid number: " + fields[1])
+		return 1, "", 0
+	}
+	return 0, fields[0], number
+}
+
+// get the options of the scan command:
+// scan <cursor> [match <regex>] [count <n>]
+// returns 1 on error
+func split_scan_options(input string) (int, uint64, string, uint64) {
+	var i int = 0
+	var cursor uint64 = 0
+	var pattern string = ""
+	var count uint64 = 10
+	var err error
+
+	fields := strings.Fields(input)
+	if len(fields) < 2 {
+		fmt.Println("split_scan_options: error no cursor set!")
+		return 1, 0, "", 0
+	}
+	cursor, err = strconv.ParseUint(fields[1], 10, 64)
+	if err != nil {
+		fmt.Println("split_scan_options: error cursor is not a number: " + fields[1])
+		return 1, 0, "", 0
+	}
+
+	for i = 2; i < len(fields); i++ {
+		if i+1 >= len(fields) {
+			// option without argument
+			return 1, 0, "", 0
+		}
+		switch strings.ToLower(fields[i]) {
+		case "match":
+			pattern = fields[i+1]
+		case "count":
+			count, err = strconv.ParseUint(fields[i+1], 10, 64)
+			if err != nil || count == 0 {
+				fmt.Println("split_scan_options: error count is not a number: " + fields[i+1])
+				return 1, 0, "", 0
+			}
+		default:
+			fmt.Println("split_scan_options: error unknown option: " + fields[i])
+			return 1, 0, "", 0
+		}
+		i++
+	}
+	return 0, cursor, pattern, count
+}
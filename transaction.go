@@ -0,0 +1,619 @@
+// transaction.go - database in go
+/*
+ * This file transaction.go is part of L1VMgodata.
+ *
+ * (c) Copyright Stefan Pietzonke (jay-t@gmx.net), 2025
+ *
+ * L1VMgodata is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * L1VMgodata is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with L1VMgodata.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+// transactions: the commands after "begin" are queued, and run at "commit" under the lock of the database.
+// So no other client sees a part of the changes. If one command fails, all changes are undone.
+// "watch :key" before "begin" aborts the commit if another client changed the key.
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// results of a commit
+const (
+	TRANSACTION_OK      = 0
+	TRANSACTION_ABORTED = 1 // a watched key was changed
+	TRANSACTION_FAILED  = 2 // a command failed, nothing was changed
+)
+
+// a transaction of a client connection
+type transaction struct {
+	active   bool
+	commands []string
+	watched  []watched_key
+}
+
+// a key watched by a client, with the change number it had at "watch"
+type watched_key struct {
+	db      *database
+	key     string
+	exists  bool
+	changed uint64
+}
+
+// the changed data entries of a running transaction on a database, to undo them if a command fails
+type transaction_journal struct {
+	length  int                       // number of data entries at the start
+	entries map[uint64]data           // the data entries before the first change
+	access  map[uint64]int64          // the eviction info of the entries
+	hits    map[uint64]uint64         // the eviction info of the entries
+	queues  map[string]*queue_counter // the queue counters before the first change, nil if not changed
+	log     []log_entry               // data log entries, written at the commit
+	evicted uint64                    // number of evicted keys
+	pushed  []string                  // lists with new elements, for the waiting clients
+}
+
+type log_entry struct {
+	entry string
+	args  []string
+}
+
+// set the change number of a data entry before it is changed, db.mutex must be locked.
+// In a transaction the entry is saved first, so the change can be undone
+func change_entry(db *database, i uint64) {
+	if db.journal != nil {
+		saved := db.journal
+		_, ok := saved.entries[i]
+		if !ok && int(i) < saved.length {
+			entry := db.data[i]
+			entry.links = append([]string(nil), entry.links...)
+			entry.linked_by = append([]string(nil), entry.linked_by...)
+			entry.history = append([]history_entry(nil), entry.history...)
+			entry.list = append([]string(nil), entry.list...)
+			entry.reserved = append([]reserved_message(nil), entry.reserved...)
+			saved.entries[i] = entry
+			saved.access[i] = db.access[i]
+			saved.hits[i] = db.hits[i]
+		}
+	}
+	db.change_number++
+	db.data[i].changed = db.change_number
+}
+
+// save the queue counters of a database before they are changed, db.mutex must be locked
+func change_queues(db *database) {
+	if db.journal == nil {
+		return
+	}
+	saved := db.journal
+	if saved.queues == nil {
+		saved.queues = make(map[string]*queue_counter)
+		for key, counter := range db.queues {
+			before := *counter
+			saved.queues[key] = &before
+		}
+	}
+}
+
+// undo all changes of the running transaction, db.mutex must be locked
+func rollback_journal(db *database) {
+	var i uint64
+
+	saved := db.journal
+
+	// remove the keys of the changed entries and of the new ones
+	for i = 0; i < uint64(len(db.data)); i++ {
+		_, changed := saved.entries[i]
+		if (changed || int(i) >= saved.length) && db.data[i].used {
+			k, ok := db.key_index[db.data[i].key]
+			if ok && k == i {
+				delete(db.key_index, db.data[i].key)
+			}
+		}
+	}
+	db.data = db.data[:saved.length]
+	db.access = db.access[:saved.length]
+	db.hits = db.hits[:saved.length]
+	if saved.queues != nil {
+		db.queues = saved.queues
+	}
+
+	for i, entry := range saved.entries {
+		db.data[i] = entry
+		db.access[i] = saved.access[i]
+		db.hits[i] = saved.hits[i]
+		if entry.used {
+			db.key_index[entry.key] = i
+		}
+	}
+
+	// the free slots, expiring keys and lists with reserved messages are set up again from the data entries
+	db.free_slots = nil
+	db.expiring = make(map[string]bool)
+	db.reserving = make(map[string]bool)
+	for i = 0; i < uint64(len(db.data)); i++ {
+		if !db.data[i].used {
+			db.free_slots = append(db.free_slots, i)
+			continue
+		}
+		if db.data[i].expire != 0 {
+			db.expiring[db.data[i].key] = true
+		}
+		if len(db.data[i].reserved) > 0 {
+			db.reserving[db.data[i].key] = true
+		}
+	}
+	count_memory(db)
+
+	// the keys evicted in the transaction are not counted
+	atomic.AddUint64(&evicted_keys, ^(saved.evicted - 1))
+	db.journal = nil
+}
+
+// watch a key, the next commit is aborted if it is changed.
+// returns 1 if a transaction is running
+func watch_key(tr *transaction, db *database, key string) int {
+	var w watched_key
+
+	if tr.active {
+		return 1
+	}
+
+	w.db = db
+	w.key = key
+	db.mutex.RLock()
+	i, ok := db.key_index[key]
+	if ok && is_used(&db.data[i], get_time_ms()) {
+		w.exists = true
+		w.changed = db.data[i].changed
+	}
+	db.mutex.RUnlock()
+	tr.watched = append(tr.watched, w)
+	return 0
+}
+
+// check if a watched key was changed, the databases of the watched keys must be locked
+func check_watched(tr *transaction) bool {
+	now := get_time_ms()
+	for _, w := range tr.watched {
+		if is_dropped(w.db) {
+			// the database was dropped
+			return true
+		}
+		i, ok := w.db.key_index[w.key]
+		exists := ok && is_used(&w.db.data[i], now)
+		if exists != w.exists || (exists && w.db.data[i].changed != w.changed) {
+			return true
+		}
+	}
+	return false
+}
+
+// check if a command can be queued in a transaction.
+// The reply of "commit" has one line for every command, so commands with more reply lines like "lrange" can't be queued.
+// returns 1 if not, 2 if it is a write command and the user is read-only
+func check_transaction_command(command string, user_role string) int {
+	var write bool
+
+	switch {
+	case strings.HasPrefix(command, GET_DATA_KEY), strings.HasPrefix(command, GET_TTL), strings.HasPrefix(command, GET_META),
+		strings.HasPrefix(command, GET_TYPE), strings.HasPrefix(command, LIST_LENGTH),
+		strings.HasPrefix(command, QUEUE_PEEK), strings.HasPrefix(command, QUEUE_STATS):
+		write = false
+	case strings.HasPrefix(command, STORE_DATA), strings.HasPrefix(command, CAS_DATA),
+		strings.HasPrefix(command, REMOVE_DATA), strings.HasPrefix(command, SET_LINK),
+		strings.HasPrefix(command, REMOVE_LINK), strings.HasPrefix(command, INCR_DATA),
+		strings.HasPrefix(command, DECR_DATA), strings.HasPrefix(command, EXPIRE_DATA),
+		strings.HasPrefix(command, PERSIST_DATA), strings.HasPrefix(command, REVERT_DATA),
+		strings.HasPrefix(command, LIST_LPUSH), strings.HasPrefix(command, LIST_RPUSH),
+		strings.HasPrefix(command, LIST_LPOP), strings.HasPrefix(command, LIST_RPOP),
+		strings.HasPrefix(command, LIST_REMOVE), strings.HasPrefix(command, QUEUE_RESERVE),
+		strings.HasPrefix(command, QUEUE_ACK), strings.HasPrefix(command, QUEUE_NACK):
+		write = true
+	default:
+		return 1
+	}
+	if write && user_role == "read-only" {
+		return 2
+	}
+	return 0
+}
+
+// run the queued commands of a transaction on the database db, all or nothing.
+// returns the result, the replies of the commands, and the number of the failed command
+func commit_transaction(tr *transaction, db *database) (int, []string, int) {
+	var replies []string
+
+	commands := tr.commands
+	tr.active = false
+	tr.commands = nil
+
+	if db == nil {
+		tr.watched = nil
+		return TRANSACTION_ABORTED, nil, 0
+	}
+	dbs := lock_transaction(tr, db)
+	if is_dropped(db) || check_watched(tr) {
+		tr.watched = nil
+		unlock_transaction(dbs, db)
+		return TRANSACTION_ABORTED, nil, 0
+	}
+	tr.watched = nil
+	// only db is changed, the databases of the watched keys can be used by other clients again
+	for _, d := range dbs {
+		if d != db {
+			d.mutex.RUnlock()
+		}
+	}
+
+	db.journal = &transaction_journal{length: len(db.data), entries: make(map[uint64]data),
+		access: make(map[uint64]int64), hits: make(map[uint64]uint64)}
+	for n, command := range commands {
+		failed, reply := run_transaction_command(db, command)
+		if failed {
+			rollback_journal(db)
+			db.mutex.Unlock()
+			fmt.Println("commit_transaction: command " + strconv.Itoa(n+1) + " failed: " + command)
+			return TRANSACTION_FAILED, nil, n + 1
+		}
+		replies = append(replies, reply)
+	}
+
+	// all commands are done, write the data log
+	entries := db.journal.log
+	pushed := db.journal.pushed
+	db.journal = nil
+	for _, e := range entries {
+		log_write(db, e.entry, e.args...)
+	}
+	for _, key := range pushed {
+		serve_list_waiters(db, key)
+	}
+	db.mutex.Unlock()
+	return TRANSACTION_OK, replies, 0
+}
+
+// lock the database of a transaction for changing data and the databases of the watched keys for reading.
+// The databases are locked in the order of their names, so two commits don't wait for each other.
+// returns the locked databases
+func lock_transaction(tr *transaction, db *database) []*database {
+	dbs := []*database{db}
+	for _, w := range tr.watched {
+		found := false
+		for _, d := range dbs {
+			if d == w.db {
+				found = true
+			}
+		}
+		if !found {
+			dbs = append(dbs, w.db)
+		}
+	}
+	sort.Slice(dbs, func(i, j int) bool {
+		return dbs[i].name < dbs[j].name
+	})
+
+	for _, d := range dbs {
+		if d == db {
+			d.mutex.Lock()
+		} else {
+			d.mutex.RLock()
+		}
+	}
+	return dbs
+}
+
+// unlock the databases locked by lock_transaction
+func unlock_transaction(dbs []*database, db *database) {
+	for _, d := range dbs {
+		if d == db {
+			d.mutex.Unlock()
+		} else {
+			d.mutex.RUnlock()
+		}
+	}
+}
+
+// run one command of a transaction, db.mutex must be locked.
+// returns true if a write command failed, and the reply of the command
+func run_transaction_command(db *database, command string) (bool, string) {
+	var ret_err int
+	var key string
+	var value string
+	var expected string
+	var seconds uint64
+	var number int64
+	var number_float float64
+	var ttl int64
+	var created int64
+	var updated int64
+	var version uint64
+	var ms int64
+	var vtype string
+	var length int
+	var id uint64
+	var ready int
+	var reserved int
+	var counter queue_counter
+
+	key = split_key(command)
+	if key == "" {
+		// a failed read command doesn't fail the transaction
+		read := strings.HasPrefix(command, GET_DATA_KEY) || strings.HasPrefix(command, GET_TTL) || strings.HasPrefix(command, GET_META) ||
+			strings.HasPrefix(command, GET_TYPE) || strings.HasPrefix(command, LIST_LENGTH) ||
+			strings.HasPrefix(command, QUEUE_PEEK) || strings.HasPrefix(command, QUEUE_STATS)
+		return !read, "ERROR"
+	}
+
+	switch {
+	case strings.HasPrefix(command, STORE_DATA_IF_ABSENT), strings.HasPrefix(command, STORE_DATA_IF_PRESENT):
+		ret_err = STORE_ERROR
+		if check_data(command) == 0 {
+			key, value = split_data(command)
+			if strings.HasPrefix(command, STORE_DATA_IF_PRESENT) {
+				ret_err = store_data_if_locked(db, key, value, STORE_IF_PRESENT, "")
+			} else {
+				ret_err = store_data_if_locked(db, key, value, STORE_IF_ABSENT, "")
+			}
+		}
+		return store_result(ret_err)
+
+	case strings.HasPrefix(command, CAS_DATA):
+		ret_err, expected, value = split_cas_values(command)
+		if ret_err != 0 {
+			return true, "ERROR"
+		}
+		return store_result(store_data_if_locked(db, key, value, STORE_IF_VALUE, expected))
+
+	case strings.HasPrefix(command, STORE_DATA_IF_VERSION):
+		ret_err, expected = split_version(command)
+		if ret_err != 0 || check_data(command) != 0 {
+			return true, "ERROR"
+		}
+		key, value = split_data(command)
+		return store_result(store_data_if_locked(db, key, value, STORE_IF_VERSION, expected))
+
+	case strings.HasPrefix(command, STORE_DATA_TTL):
+		ret_err, seconds = split_ttl(command)
+		if ret_err != 0 || check_data(command) != 0 {
+			return true, "ERROR"
+		}
+		key, value = split_data(command)
+		if store_data_ttl_locked(db, key, value, seconds) != 0 {
+			return true, "ERROR"
+		}
+		return false, "OK"
+
+	case strings.HasPrefix(command, STORE_DATA_TYPE):
+		ret_err, vtype = split_type(command)
+		if ret_err != 0 || check_data(command) != 0 {
+			return true, "ERROR"
+		}
+		key, value = split_data(command)
+		if store_data_type_locked(db, key, value, vtype) != 0 {
+			return true, "ERROR"
+		}
+		return false, "OK"
+
+	case strings.HasPrefix(command, STORE_DATA):
+		// also "store data new"
+		if check_data(command) != 0 {
+			return true, "ERROR"
+		}
+		key, value = split_data(command)
+		if store_data_locked(db, key, value) != 0 {
+			return true, "ERROR"
+		}
+		return false, "OK"
+
+	case strings.HasPrefix(command, GET_DATA_KEY_AT):
+		ret_err, ms = split_time(command)
+		if ret_err == 0 {
+			ret_err, value = get_data_key_at_locked(db, key, ms)
+		}
+		if ret_err != 0 {
+			return false, "ERROR"
+		}
+		return false, value
+
+	case strings.HasPrefix(command, REVERT_DATA):
+		version, err := strconv.ParseUint(split_value(command), 10, 64)
+		if err != nil || revert_data_locked(db, key, version) != 0 {
+			return true, "ERROR"
+		}
+		return false, "OK"
+
+	case strings.HasPrefix(command, GET_DATA_KEY):
+		value = get_data_key_locked(db, key)
+		if value == "" {
+			return false, "ERROR"
+		}
+		return false, value
+
+	case strings.HasPrefix(command, REMOVE_DATA):
+		value = remove_data_locked(db, key)
+		if value == "" {
+			return true, "ERROR"
+		}
+		return false, value
+
+	case strings.HasPrefix(command, EXPIRE_DATA):
+		ret_err, seconds = split_seconds(split_value(command))
+		if ret_err != 0 || set_expire_time_locked(db, key, get_time_ms()+int64(seconds)*1000) != 0 {
+			return true, "ERROR"
+		}
+		return false, "OK"
+
+	case strings.HasPrefix(command, GET_TTL):
+		ret_err, ttl = get_ttl_locked(db, key)
+		if ret_err != 0 {
+			return false, "ERROR"
+		}
+		return false, strconv.FormatInt(ttl, 10)
+
+	case strings.HasPrefix(command, GET_META):
+		ret_err, created, updated, version = get_meta_locked(db, key)
+		if ret_err != 0 {
+			return false, "ERROR"
+		}
+		return false, format_meta(created, updated, version)
+
+	case strings.HasPrefix(command, GET_TYPE):
+		ret_err, vtype = get_type_locked(db, key)
+		if ret_err != 0 {
+			return false, "ERROR"
+		}
+		return false, vtype
+
+	case strings.HasPrefix(command, LIST_LPUSH), strings.HasPrefix(command, LIST_RPUSH):
+		if check_data(command) != 0 {
+			return true, "ERROR"
+		}
+		key, value = split_data(command)
+		ret_err, length = push_list_locked(db, key, value, strings.HasPrefix(command, LIST_LPUSH))
+		return list_result(ret_err, strconv.Itoa(length))
+
+	case strings.HasPrefix(command, LIST_LPOP), strings.HasPrefix(command, LIST_RPOP):
+		return list_result(pop_list_locked(db, key, strings.HasPrefix(command, LIST_LPOP)))
+
+	case strings.HasPrefix(command, LIST_REMOVE):
+		ret_err, expected, value = split_cas_values(command)
+		if ret_err != 0 {
+			return true, "ERROR"
+		}
+		number, err := strconv.ParseInt(expected, 10, 64)
+		if err != nil {
+			return true, "ERROR"
+		}
+		ret_err, length = remove_list_locked(db, key, number, value)
+		return list_result(ret_err, strconv.Itoa(length))
+
+	case strings.HasPrefix(command, LIST_LENGTH):
+		ret_err, length = get_list_length_locked(db, key)
+		if ret_err != LIST_OK {
+			return false, "ERROR"
+		}
+		return false, strconv.Itoa(length)
+
+	case strings.HasPrefix(command, QUEUE_RESERVE):
+		seconds = QUEUE_VISIBILITY
+		if split_value(command) != "" {
+			ret_err, seconds = split_seconds(split_value(command))
+			if ret_err != 0 {
+				return true, "ERROR"
+			}
+		}
+		ret_err, id, value = reserve_queue_locked(db, key, 0, get_time_ms()+int64(seconds)*1000)
+		return list_result(ret_err, strconv.FormatUint(id, 10)+" '"+value+"'")
+
+	case strings.HasPrefix(command, QUEUE_ACK), strings.HasPrefix(command, QUEUE_NACK):
+		id, err := strconv.ParseUint(split_value(command), 10, 64)
+		if err != nil {
+			return true, "ERROR"
+		}
+		if strings.HasPrefix(command, QUEUE_ACK) {
+			ret_err = ack_queue_locked(db, key, id)
+		} else {
+			ret_err = nack_queue_locked(db, key, id)
+		}
+		return list_result(ret_err, "OK")
+
+	case strings.HasPrefix(command, QUEUE_PEEK):
+		ret_err, value = peek_queue_locked(db, key)
+		_, value = list_result(ret_err, value)
+		return false, value
+
+	case strings.HasPrefix(command, QUEUE_STATS):
+		ret_err, ready, reserved, counter = get_queue_stats_locked(db, key)
+		_, value = list_result(ret_err, format_queue_stats(ready, reserved, counter))
+		return false, value
+
+	case strings.HasPrefix(command, PERSIST_DATA):
+		if set_expire_time_locked(db, key, 0) != 0 {
+			return true, "ERROR"
+		}
+		return false, "OK"
+
+	case strings.HasPrefix(command, INCR_FLOAT_DATA):
+		ret_err, number_float = split_number_float(command)
+		if ret_err != 0 {
+			return true, "ERROR"
+		}
+		return counter_result(change_counter(db, key, incr_float_change(key, number_float)))
+
+	case strings.HasPrefix(command, INCR_DATA), strings.HasPrefix(command, DECR_DATA):
+		ret_err, number = split_number(command)
+		if ret_err != 0 {
+			return true, "ERROR"
+		}
+		if strings.HasPrefix(command, DECR_DATA) {
+			number = -number
+		}
+		return counter_result(change_counter(db, key, incr_change(key, number)))
+
+	case strings.HasPrefix(command, SET_LINK), strings.HasPrefix(command, REMOVE_LINK):
+		value = split_value(command)
+		if value == "" {
+			return true, "ERROR"
+		}
+		if strings.HasPrefix(command, SET_LINK) {
+			ret_err = set_link_locked(db, key, value)
+		} else {
+			ret_err = remove_link_locked(db, key, value)
+		}
+		if ret_err != 0 {
+			return true, "ERROR"
+		}
+		return false, "OK"
+	}
+	return true, "ERROR"
+}
+
+// the reply of a conditional store in a transaction, a failed condition fails the transaction
+func store_result(ret_err int) (bool, string) {
+	switch ret_err {
+	case STORE_OK:
+		return false, "OK"
+	case STORE_FAILED:
+		return true, "FAILED"
+	}
+	return true, "ERROR"
+}
+
+// the reply of a counter in a transaction
+func counter_result(ret_err int, value string) (bool, string) {
+	switch ret_err {
+	case COUNTER_OK:
+		return false, value
+	case COUNTER_NOT_NUMBER:
+		return true, "ERROR value is not a number!"
+	}
+	return true, "ERROR"
+}
+
+// the reply of a list command in a transaction
+func list_result(ret_err int, value string) (bool, string) {
+	switch ret_err {
+	case LIST_OK:
+		return false, value
+	case LIST_NOT_LIST:
+		return true, "ERROR value is not a list!"
+	case LIST_EMPTY:
+		// nothing was changed
+		return false, "EMPTY"
+	}
+	return true, "ERROR"
+}
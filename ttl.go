@@ -0,0 +1,210 @@
+// ttl.go - database in go
+/*
+ * This file ttl.go is part of L1VMgodata.
+ *
+ * (c) Copyright Stefan Pietzonke (jay-t@gmx.net), 2025
+ *
+ * L1VMgodata is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * L1VMgodata is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with L1VMgodata.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+// time to live of keys: a key with an expire time is removed when the time is over.
+// An expired key is not found by any command, also if it is not removed yet.
+// It is removed on the next change of the key, or by the expire loop every second.
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+const (
+	TTL_MAX_SECONDS   = 100 * 365 * 24 * 3600 // max time to live: 100 years
+	EXPIRE_SWEEP_KEYS = 1000                  // max keys checked by the expire loop at once
+)
+
+// get the unix time in milliseconds, the expire times are stored in it
+func get_time_ms() int64 {
+	return time.Now().UnixMilli()
+}
+
+// check if a data entry is used and not expired, db.mutex must be locked
+func is_used(d *data, now int64) bool {
+	return d.used && (d.expire == 0 || d.expire > now)
+}
+
+// get the data index of a key which is not expired, db.mutex must be locked.
+// It is an access of the key for the eviction
+func get_key_index(db *database, key string) (uint64, bool) {
+	i, ok := db.key_index[key]
+	if !ok || !is_used(&db.data[i], get_time_ms()) {
+		return 0, false
+	}
+	touch_data(db, i)
+	return i, true
+}
+
+// set the expire time of a data entry, 0 = never. db.mutex must be locked
+func set_expire(db *database, i uint64, expire int64) {
+	change_entry(db, i)
+	db.data[i].expire = expire
+	if expire == 0 {
+		delete(db.expiring, db.data[i].key)
+	} else {
+		db.expiring[db.data[i].key] = true
+	}
+}
+
+// remove a key if it is expired, db.mutex must be locked.
+// returns 1 if the key was removed
+func expire_key(db *database, key string) int {
+	i, ok := db.key_index[key]
+	if !ok || is_used(&db.data[i], get_time_ms()) {
+		return 0
+	}
+	delete_data(db, i)
+	remove_queue(db, key)
+	log_write(db, LOG_REMOVE, key)
+	return 1
+}
+
+// store a key which is removed after seconds
+// returns 1 on error
+func store_data_ttl(db *database, key string, value string, seconds uint64) uint64 {
+	db.mutex.Lock()
+	err := store_data_ttl_locked(db, key, value, seconds)
+	db.mutex.Unlock()
+	return err
+}
+
+// db.mutex must be locked
+func store_data_ttl_locked(db *database, key string, value string, seconds uint64) uint64 {
+	var err int = 0
+	var i uint64
+
+	expire := get_time_ms() + int64(seconds)*1000
+
+	err, i = set_data(db, key, value)
+	if err == 1 {
+		fmt.Println("error: can't get free space for data!")
+		return 1
+	}
+	set_expire(db, i, expire)
+	log_write(db, LOG_STORE, key, value, strconv.FormatInt(db.data[i].updated, 10))
+	log_write(db, LOG_EXPIRE, key, strconv.FormatInt(expire, 10))
+	return 0
+}
+
+// set the expire time of a key to now + seconds
+// returns 1 if the key is not found
+func expire_data(db *database, key string, seconds uint64) int {
+	return set_expire_time(db, key, get_time_ms()+int64(seconds)*1000)
+}
+
+// set the expire time of a key in unix milliseconds, 0 = never
+// returns 1 if the key is not found
+func set_expire_time(db *database, key string, expire int64) int {
+	db.mutex.Lock()
+	err := set_expire_time_locked(db, key, expire)
+	db.mutex.Unlock()
+	return err
+}
+
+// db.mutex must be locked
+func set_expire_time_locked(db *database, key string, expire int64) int {
+	expire_key(db, key)
+	i, ok := db.key_index[key]
+	if !ok {
+		return 1
+	}
+	set_expire(db, i, expire)
+	if expire == 0 {
+		log_write(db, LOG_PERSIST, key)
+	} else {
+		log_write(db, LOG_EXPIRE, key, strconv.FormatInt(expire, 10))
+	}
+	return 0
+}
+
+// remove the expire time of a key, it is kept until it is removed
+// returns 1 if the key is not found
+func persist_data(db *database, key string) int {
+	return set_expire_time(db, key, 0)
+}
+
+// get the seconds until the key expires, -1 if it doesn't expire
+// returns 1 if the key is not found
+func get_ttl(db *database, key string) (int, int64) {
+	db.mutex.RLock()
+	err, ttl := get_ttl_locked(db, key)
+	db.mutex.RUnlock()
+	return err, ttl
+}
+
+// db.mutex must be locked
+func get_ttl_locked(db *database, key string) (int, int64) {
+	var ttl int64 = -1
+
+	i, ok := get_key_index(db, key)
+	if !ok {
+		return 1, 0
+	}
+	if db.data[i].expire != 0 {
+		// round up, a key with less than one second left has a ttl of 1
+		ttl = (db.data[i].expire - get_time_ms() + 999) / 1000
+	}
+	return 0, ttl
+}
+
+// remove the expired keys of all databases every second
+func expire_loop() {
+	for {
+		time.Sleep(time.Second)
+
+		for _, db := range get_databases() {
+			removed := sweep_expired(db)
+			for removed > EXPIRE_SWEEP_KEYS/4 {
+				// many keys are expired, check again
+				removed = sweep_expired(db)
+			}
+		}
+	}
+}
+
+// remove expired keys, at most EXPIRE_SWEEP_KEYS are checked.
+// So other clients don't wait too long for the lock of the database.
+// returns the number of removed keys
+func sweep_expired(db *database) int {
+	var checked int = 0
+	var removed int = 0
+
+	db.mutex.Lock()
+	now := get_time_ms()
+	for key := range db.expiring {
+		if checked == EXPIRE_SWEEP_KEYS {
+			break
+		}
+		checked++
+		i := db.key_index[key]
+		if !is_used(&db.data[i], now) {
+			delete_data(db, i)
+			remove_queue(db, key)
+			log_write(db, LOG_REMOVE, key)
+			removed++
+		}
+	}
+	db.mutex.Unlock()
+	return removed
+}
@@ -0,0 +1,187 @@
+// types.go - database in go
+/*
+ * This file types.go is part of L1VMgodata.
+ *
+ * (c) Copyright Stefan Pietzonke (jay-t@gmx.net), 2025
+ *
+ * L1VMgodata is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * L1VMgodata is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with L1VMgodata.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+// typed values: a value can have a type, it is checked when the value is stored.
+// Without a type a value is a string. Binary data is stored as base64 text with the type "bytes".
+// The type is saved in the database files, the JSON export writes int, float and bool values as JSON numbers and booleans.
+// "list compare" compares the int and float values with a number.
+
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+const (
+	TYPE_STRING = "string"
+	TYPE_INT    = "int"
+	TYPE_FLOAT  = "float"
+	TYPE_BOOL   = "bool"
+	TYPE_BYTES  = "bytes"
+	TYPE_LIST   = "list" // set by the list commands, not by "store data type"
+)
+
+// check the name of a type, returns true if it is not known
+func check_type_name(vtype string) bool {
+	switch vtype {
+	case TYPE_STRING, TYPE_INT, TYPE_FLOAT, TYPE_BOOL, TYPE_BYTES:
+		return false
+	}
+	fmt.Println("Error type: " + vtype + " is not known!")
+	return true
+}
+
+// check if a value has the type, numbers and booleans are returned in their normal form:
+// "+007" is stored as int "7", "TRUE" as bool "true".
+// returns 1 if the value doesn't have the type
+func check_value_type(vtype string, value string) (int, string) {
+	switch vtype {
+	case TYPE_STRING:
+		return 0, value
+	case TYPE_INT:
+		number, err := strconv.ParseInt(value, 10, 64)
+		if err == nil {
+			return 0, strconv.FormatInt(number, 10)
+		}
+	case TYPE_FLOAT:
+		number, err := strconv.ParseFloat(value, 64)
+		if err == nil && !math.IsNaN(number) && !math.IsInf(number, 0) {
+			return 0, strconv.FormatFloat(number, 'f', -1, 64)
+		}
+	case TYPE_BOOL:
+		b, err := strconv.ParseBool(value)
+		if err == nil {
+			return 0, strconv.FormatBool(b)
+		}
+	case TYPE_BYTES:
+		_, err := base64.StdEncoding.DecodeString(value)
+		if err == nil {
+			return 0, value
+		}
+	}
+	fmt.Println("check_value_type: error value is not a " + vtype + ": " + value)
+	return 1, ""
+}
+
+// store a value with a type
+// returns 1 if the type is not known, the value doesn't have the type or there is no free space
+func store_data_type(db *database, key string, value string, vtype string) int {
+	db.mutex.Lock()
+	err := store_data_type_locked(db, key, value, vtype)
+	db.mutex.Unlock()
+	return err
+}
+
+// db.mutex must be locked
+func store_data_type_locked(db *database, key string, value string, vtype string) int {
+	var err int
+	var i uint64
+
+	if check_type_name(vtype) {
+		return 1
+	}
+	err, value = check_value_type(vtype, value)
+	if err != 0 {
+		return 1
+	}
+
+	err, i = set_data(db, key, value)
+	if err == 1 {
+		fmt.Println("error: can't get free space for data!")
+		return 1
+	}
+	set_type(db, i, vtype)
+	log_write(db, LOG_STORE, key, value, strconv.FormatInt(db.data[i].updated, 10))
+	if vtype != TYPE_STRING {
+		log_write(db, LOG_TYPE, key, vtype)
+	}
+	return 0
+}
+
+// set the type of a data entry, db.mutex must be locked
+func set_type(db *database, i uint64, vtype string) {
+	change_entry(db, i)
+	db.data[i].vtype = vtype
+}
+
+// get the type of a key
+// returns 1 if the key is not found
+func get_type(db *database, key string) (int, string) {
+	db.mutex.RLock()
+	err, vtype := get_type_locked(db, key)
+	db.mutex.RUnlock()
+	return err, vtype
+}
+
+// db.mutex must be locked
+func get_type_locked(db *database, key string) (int, string) {
+	i, ok := get_key_index(db, key)
+	if !ok {
+		return 1, ""
+	}
+	return 0, db.data[i].vtype
+}
+
+// the value in a JSON export: int, float and bool values are not quoted
+func format_value_json(value string, vtype string) string {
+	switch vtype {
+	case TYPE_INT, TYPE_FLOAT, TYPE_BOOL:
+		return value
+	}
+	return quote_json(value)
+}
+
+// check if the value of a data entry compares with the number, by the operator:
+// "<", "<=", ">", ">=", "==" or "!=". Only int and float values are compared
+func compare_value(d *data, operator string, number float64) bool {
+	if d.vtype != TYPE_INT && d.vtype != TYPE_FLOAT {
+		return false
+	}
+	value, err := strconv.ParseFloat(d.value, 64)
+	if err != nil {
+		return false
+	}
+	switch operator {
+	case "<":
+		return value < number
+	case "<=":
+		return value <= number
+	case ">":
+		return value > number
+	case ">=":
+		return value >= number
+	case "==":
+		return value == number
+	case "!=":
+		return value != number
+	}
+	return false
+}
+
+// get all keys and values of int and float values which compare with the number
+func get_data_list_compare(db *database, operator string, number float64, limit uint64, offset uint64) (int, []string, []string) {
+	keys, values := get_data_list(db, func(d *data) bool {
+		return compare_value(d, operator, number)
+	}, limit, offset)
+	return 0, keys, values
+}
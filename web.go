@@ -21,13 +21,19 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"strconv"
+	"sync"
+	"time"
 )
 
+var http_server *http.Server = nil
+var hmutex sync.Mutex // web server mutex
+
 func send_form_head(w http.ResponseWriter) {
 	fmt.Fprintf(w, "<!DOCTYPE html>")
 	fmt.Fprintf(w, "<html>")
@@ -59,15 +65,19 @@ func parse_web(w http.ResponseWriter, command string, key string, value string)
 	var key_ret string
 	var value_ret string
 	var used_elements uint64
+	var data_size uint64
 	var linkslen uint64
 	var retstr string
 	var linkindex uint64
 
+	// the web form uses the default database
+	db := get_database(DEFAULT_DATABASE)
+
 	switch command {
 	case STORE_DATA:
 		send_form_head(w)
 
-		if store_data(key, value) != 0 {
+		if store_data(db, key, value) != 0 {
 			fmt.Fprintf(w, "ERROR can't store data!\n")
 		} else {
 			fmt.Fprintf(w, "data stored!\n")
@@ -78,7 +88,23 @@ func parse_web(w http.ResponseWriter, command string, key string, value string)
 	case GET_DATA_KEY:
 		send_form_head(w)
 
-		value_ret = get_data_key(key)
+		value_ret = get_data_key(db, key)
+		fmt.Fprintf(w, "key: %s, value: %s\n", key, value_ret)
+
+		send_form_end(w)
+
+	case GET_DATA_KEY_CONTAINS:
+		send_form_head(w)
+
+		value_ret = get_data_key_contains(db, key)
+		fmt.Fprintf(w, "key: %s, value: %s\n", key, value_ret)
+
+		send_form_end(w)
+
+	case GET_DATA_KEY_PREFIX:
+		send_form_head(w)
+
+		value_ret = get_data_key_prefix(db, key)
 		fmt.Fprintf(w, "key: %s, value: %s\n", key, value_ret)
 
 		send_form_end(w)
@@ -86,7 +112,7 @@ func parse_web(w http.ResponseWriter, command string, key string, value string)
 	case GET_DATA_VALUE:
 		send_form_head(w)
 
-		key_ret = get_data_value(value)
+		key_ret = get_data_value(db, value)
 		fmt.Fprintf(w, "key: %s, value: %s\n", key_ret, value)
 
 		send_form_end(w)
@@ -94,7 +120,7 @@ func parse_web(w http.ResponseWriter, command string, key string, value string)
 	case REMOVE_DATA:
 		send_form_head(w)
 
-		value_ret = remove_data(key)
+		value_ret = remove_data(db, key)
 		fmt.Fprintf(w, "%s\n", value_ret)
 
 		send_form_end(w)
@@ -102,7 +128,7 @@ func parse_web(w http.ResponseWriter, command string, key string, value string)
 	case GET_DATA_REGEXP_KEY:
 		send_form_head(w)
 
-		value_ret = get_data_key_regexp(key)
+		value_ret = get_data_key_regexp(db, key)
 		fmt.Fprintf(w, "%s\n", value_ret)
 
 		send_form_end(w)
@@ -110,7 +136,7 @@ func parse_web(w http.ResponseWriter, command string, key string, value string)
 	case GET_DATA_REGEXP_VALUE:
 		send_form_head(w)
 
-		key_ret = get_data_value_regexp(value)
+		key_ret = get_data_value_regexp(db, value)
 		fmt.Fprintf(w, "%s\n", key_ret)
 
 		send_form_end(w)
@@ -118,7 +144,7 @@ func parse_web(w http.ResponseWriter, command string, key string, value string)
 	case SAVE_DATA:
 		send_form_head(w)
 
-		if save_data(value) != 0 {
+		if save_data(db, value) != 0 {
 			fmt.Fprintf(w, "ERROR can't save database %s !\n", value)
 		} else {
 			fmt.Fprintf(w, "database %s saved!\n", value)
@@ -129,7 +155,9 @@ func parse_web(w http.ResponseWriter, command string, key string, value string)
 	case LOAD_DATA:
 		send_form_head(w)
 
-		if load_data(value) != 0 {
+		ret := load_data(db, value)
+		log_import()
+		if ret != 0 {
 			fmt.Fprintf(w, "ERROR can't load database %s !\n", value)
 		} else {
 			fmt.Fprintf(w, "database %s loaded!\n", value)
@@ -140,7 +168,7 @@ func parse_web(w http.ResponseWriter, command string, key string, value string)
 	case SAVE_DATA_JSON:
 		send_form_head(w)
 
-		if save_data_json(value) != 0 {
+		if save_data_json(db, value) != 0 {
 			fmt.Fprintf(w, "ERROR can't save JSON database %s !\n", value)
 		} else {
 			fmt.Fprintf(w, "JSON database %s saved!\n", value)
@@ -151,7 +179,9 @@ func parse_web(w http.ResponseWriter, command string, key string, value string)
 	case LOAD_DATA_JSON:
 		send_form_head(w)
 
-		if load_data_json(value) != 0 {
+		ret := load_data_json(db, value)
+		log_import()
+		if ret != 0 {
 			fmt.Fprintf(w, "ERROR can't load JSON database %s !\n", value)
 		} else {
 			fmt.Fprintf(w, "JSON database %s loaded!\n", value)
@@ -162,7 +192,7 @@ func parse_web(w http.ResponseWriter, command string, key string, value string)
 	case ERASE_DATA:
 		send_form_head(w)
 
-		init_data()
+		erase_data(db)
 		fmt.Fprintf(w, "ALL DATA ERASED!\n")
 
 		send_form_end(w)
@@ -170,15 +200,24 @@ func parse_web(w http.ResponseWriter, command string, key string, value string)
 	case GET_USED_ELEMENTS:
 		send_form_head(w)
 
-		used_elements = get_used_elements()
-		fmt.Fprintf(w, "usage: %d of %d\n", used_elements, maxdata)
+		used_elements, data_size = get_used_elements(db)
+		if data_size > 0 {
+			fmt.Fprintf(w, "usage: %d of %d\n", used_elements, data_size)
+		} else {
+			fmt.Fprintf(w, "usage: %d keys\n", used_elements)
+		}
+		used_memory, evicted := get_memory_usage()
+		if max_memory > 0 {
+			fmt.Fprintf(w, "memory: %d of %d\n", used_memory, max_memory)
+		}
+		fmt.Fprintf(w, "evicted: %d\n", evicted)
 
 		send_form_end(w)
 
 	case SET_LINK:
 		send_form_head(w)
 
-		if set_link(key, value) != 0 {
+		if set_link(db, key, value) != 0 {
 			fmt.Fprintf(w, "ERROR can't set link %s !\n", value)
 		} else {
 			fmt.Fprintf(w, "link set!\n")
@@ -189,7 +228,7 @@ func parse_web(w http.ResponseWriter, command string, key string, value string)
 	case REMOVE_LINK:
 		send_form_head(w)
 
-		if remove_link(key, value) != 0 {
+		if remove_link(db, key, value) != 0 {
 			fmt.Fprintf(w, "ERROR can't remove link %s !\n", value)
 		} else {
 			fmt.Fprintf(w, "link removed!\n")
@@ -200,7 +239,7 @@ func parse_web(w http.ResponseWriter, command string, key string, value string)
 	case GET_LINKS_NUMBER:
 		send_form_head(w)
 
-		linkslen, retstr = get_number_of_links(key)
+		linkslen, retstr = get_number_of_links(db, key)
 		if retstr == "" {
 			fmt.Fprintf(w, "ERROR can't get links number!\n")
 		} else {
@@ -214,7 +253,7 @@ func parse_web(w http.ResponseWriter, command string, key string, value string)
 
 		linkindex, _ = strconv.ParseUint(value, 10, 64)
 
-		retstr = get_link(key, linkindex)
+		retstr = get_link(db, key, linkindex)
 		if retstr == "" {
 			fmt.Fprintf(w, "ERROR can't get links name!\n")
 		} else {
@@ -271,5 +310,32 @@ func handle_http_request() {
 	// http.Handle("/", http.FileServer(http.Dir(*directory)))
 	http.HandleFunc("/", hello)
 	// log.Printf("Serving %s on HTTP port: %s\n", *directory, *port)
-	log.Fatal(http.ListenAndServe(":"+*port, nil))
+
+	hmutex.Lock()
+	http_server = &http.Server{Addr: ":" + *port}
+	server := http_server
+	hmutex.Unlock()
+
+	err := server.ListenAndServe()
+	if err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+}
+
+// stop the web server, running requests are finished first
+func shutdown_http() {
+	hmutex.Lock()
+	server := http_server
+	hmutex.Unlock()
+
+	if server == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(shutdown_timeout)*time.Second)
+	defer cancel()
+	err := server.Shutdown(ctx)
+	if err != nil {
+		print_message("shutdown_http: Error: " + err.Error())
+	}
 }